@@ -0,0 +1,168 @@
+package packer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Algorithm is a method of packing blocks into a canvas.
+//go:generate stringer -type=Algorithm
+type Algorithm int
+
+const (
+	// AlgorithmBinaryTree grows a binary tree of free space, based on Jake
+	// Gordon's bin-packing
+	AlgorithmBinaryTree Algorithm = iota
+	// AlgorithmMaxRectsBSSF is MaxRects placing blocks by best short side fit
+	AlgorithmMaxRectsBSSF
+	// AlgorithmMaxRectsBAF is MaxRects placing blocks by best area fit
+	AlgorithmMaxRectsBAF
+	// AlgorithmMaxRectsBL is MaxRects placing blocks bottom left
+	AlgorithmMaxRectsBL
+	// AlgorithmSkyline places blocks on the lowest part of a skyline
+	AlgorithmSkyline
+	// AlgorithmShelf places blocks on rows of shelves
+	AlgorithmShelf
+	// AlgorithmVertical places blocks in a single column
+	AlgorithmVertical
+	// AlgorithmHorizontal places blocks in a single row
+	AlgorithmHorizontal
+)
+
+// Algorithms lists the algorithms Fit tries.  The strips, AlgorithmVertical
+// and AlgorithmHorizontal, are only used when asked for.
+var Algorithms = []Algorithm{
+	AlgorithmBinaryTree,
+	AlgorithmMaxRectsBSSF,
+	AlgorithmMaxRectsBAF,
+	AlgorithmMaxRectsBL,
+	AlgorithmSkyline,
+	AlgorithmShelf,
+}
+
+// algorithmNames are the names used to select an algorithm in the config and CLI
+var algorithmNames = map[string]Algorithm{
+	"binary-tree":   AlgorithmBinaryTree,
+	"maxrects-bssf": AlgorithmMaxRectsBSSF,
+	"maxrects-baf":  AlgorithmMaxRectsBAF,
+	"maxrects-bl":   AlgorithmMaxRectsBL,
+	"skyline":       AlgorithmSkyline,
+	"shelf":         AlgorithmShelf,
+}
+
+// ParseAlgorithm returns the algorithm with the given name, e.g. "maxrects-bssf".
+func ParseAlgorithm(name string) (Algorithm, error) {
+	a, ok := algorithmNames[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown packing algorithm %q", name)
+	}
+
+	return a, nil
+}
+
+// AlgorithmNames returns the names of all algorithms, sorted.
+func AlgorithmNames() []string {
+	names := make([]string, 0, len(algorithmNames))
+	for name := range algorithmNames {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Name returns the name used to select algorithm a, e.g. "maxrects-bssf",
+// or "vertical" and "horizontal" for the strips.
+func (a Algorithm) Name() string {
+	for name, alg := range algorithmNames {
+		if alg == a {
+			return name
+		}
+	}
+
+	switch a {
+	case AlgorithmVertical:
+		return "vertical"
+	case AlgorithmHorizontal:
+		return "horizontal"
+	}
+
+	return a.String()
+}
+
+// FixedWidth reports whether algorithm a can pack blocks into a bin of a
+// fixed width, growing only downward.  The binary tree grows in both
+// directions and a horizontal strip grows to the right, so they cannot.
+func (a Algorithm) FixedWidth() bool {
+	return a != AlgorithmBinaryTree && a != AlgorithmHorizontal
+}
+
+// pack lays out blocks, already sorted, using algorithm a.  Algorithms that
+// pack into a fixed width use width, or when it is 0 try a few widths and
+// keep the smallest canvas.
+func (a Algorithm) pack(blocks Blocks, width int) *Canvas {
+	switch a {
+	case AlgorithmBinaryTree:
+		return fit(blocks)
+	case AlgorithmVertical:
+		return fitStrip(blocks, false)
+	case AlgorithmHorizontal:
+		return fitStrip(blocks, true)
+	}
+
+	var best *Canvas
+	for _, w := range binWidths(blocks, width) {
+		var canvas *Canvas
+		switch a {
+		case AlgorithmMaxRectsBSSF:
+			canvas = fitMaxRects(blocks, w, bestShortSideFit)
+		case AlgorithmMaxRectsBAF:
+			canvas = fitMaxRects(blocks, w, bestAreaFit)
+		case AlgorithmMaxRectsBL:
+			canvas = fitMaxRects(blocks, w, bottomLeft)
+		case AlgorithmSkyline:
+			canvas = fitSkyline(blocks, w)
+		default:
+			canvas = fitShelf(blocks, w)
+		}
+
+		if best == nil || canvas.Root.Width*canvas.Root.Height < best.Root.Width*best.Root.Height {
+			best = canvas
+		}
+	}
+
+	return best
+}
+
+// binWidths returns the widths of the bins to pack into: width itself, or
+// when it is 0, a few widths around the square root of the total area.
+func binWidths(blocks Blocks, width int) []int {
+	if width > 0 {
+		return []int{width}
+	}
+
+	area := 0
+	for _, b := range blocks {
+		area += b.Width * b.Height
+	}
+
+	side := math.Sqrt(float64(area))
+
+	var widths []int
+	for _, f := range []float64{0.8, 1, 1.25} {
+		widths = append(widths, int(math.Ceil(side*f)))
+	}
+
+	return widths
+}
+
+// binWidth returns width, widened if needed to hold the widest block.
+func binWidth(blocks Blocks, width int) int {
+	for _, b := range blocks {
+		width = max(width, b.Width)
+	}
+
+	return width
+}
@@ -0,0 +1,94 @@
+package packer
+
+import "strconv"
+
+// FitBins packs blocks into as many canvases (pages) as needed for none to
+// be larger than w x h, for callers whose images have a size limit.  A w or
+// h of 0 is unlimited.  Each page is packed like FitWidth, keeping the
+// blocks that fall within the limits and moving the rest to the next page.
+// A block larger than w x h gets a page of its own, larger than the limits.
+func FitBins(blocks Blocks, w, h int) []*Canvas {
+	return fitBins(blocks, w, h, FitOptions{}.defaults())
+}
+
+// fitBins packs blocks onto pages of at most w x h, packing each page as
+// set by opts.  Pages are packed opts.Width wide when it is set, and w wide
+// otherwise.  Algorithms that cannot pack into a fixed width, such as a
+// horizontal strip, are only used when no others are given.  Each page is
+// padded to opts.Sizing, so the limits should be sizes it allows.
+func fitBins(blocks Blocks, w, h int, opts FitOptions) []*Canvas {
+	if opts.Width == 0 || (w > 0 && w < opts.Width) {
+		opts.Width = w
+	}
+
+	var fixed []Algorithm
+	for _, a := range opts.Algorithms {
+		if a.FixedWidth() {
+			fixed = append(fixed, a)
+		}
+	}
+
+	if len(fixed) > 0 {
+		opts.Algorithms = fixed
+	} else {
+		opts.Width = 0
+	}
+
+	// Blocks are named by their index while packing, so names need not be
+	// unique, and keep their order for the next page.
+	remaining := make([]int, len(blocks))
+	for i := range blocks {
+		remaining[i] = i
+	}
+
+	var pages []*Canvas
+	for len(remaining) > 0 {
+		indexed := make(Blocks, len(remaining))
+		for i, j := range remaining {
+			indexed[i] = NewBlock(strconv.Itoa(j), blocks[j].Width, blocks[j].Height)
+		}
+
+		canvas := fitAll(indexed, opts)
+
+		var kept Blocks
+		for _, b := range canvas.Blocks {
+			if (w == 0 || b.X+b.Width <= w) && (h == 0 || b.Y+b.Height <= h) {
+				kept = append(kept, b)
+			}
+		}
+
+		if len(kept) == 0 {
+			// too large for any page, give it a page of its own
+			b := canvas.Blocks[0]
+			b.X, b.Y = 0, 0
+			kept = Blocks{b}
+		}
+
+		page := &Canvas{Root: NewBlock("#root#", 0, 0), Algorithm: canvas.Algorithm, Layout: canvas.Layout}
+		placed := make(map[int]bool)
+		for _, b := range kept {
+			j, _ := strconv.Atoi(b.Name)
+			b.Name = blocks[j].Name
+			placed[j] = true
+
+			page.Blocks = append(page.Blocks, b)
+			page.Root.Width = max(page.Root.Width, b.X+b.Width)
+			page.Root.Height = max(page.Root.Height, b.Y+b.Height)
+		}
+
+		opts.Sizing.padCanvas(page)
+		page.Score = opts.Scorer.Score(page)
+		pages = append(pages, page)
+
+		rest := remaining[:0]
+		for _, j := range remaining {
+			if !placed[j] {
+				rest = append(rest, j)
+			}
+		}
+
+		remaining = rest
+	}
+
+	return pages
+}
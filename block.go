@@ -9,10 +9,18 @@ type Block struct {
 	Y      int
 	Width  int
 	Height int
-	used   bool
-	fit    *Block
-	right  *Block
-	down   *Block
+	// Rotated is set when the block was packed turned by 90 degrees, so
+	// Width and Height are swapped.  Only FitOptimized turns blocks.
+	Rotated bool
+	used    bool
+	fit     *Block
+	right   *Block
+	down    *Block
+}
+
+// NewBlock returns a block of the given size, not yet placed in a canvas.
+func NewBlock(name string, w, h int) *Block {
+	return &Block{Name: name, Width: w, Height: h}
 }
 
 // Blocks is a slice of Blocks
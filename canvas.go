@@ -6,7 +6,33 @@ import "fmt"
 type Canvas struct {
 	Root   *Block
 	Blocks Blocks
-	layout Layout
+	// Score is the score given to the canvas by the Scorer that chose it.
+	Score float64
+	// Algorithm and Layout are the packing algorithm and sort order that
+	// placed the blocks.
+	Algorithm Algorithm
+	Layout    Layout
+}
+
+// Waste returns the number of pixels in the canvas not covered by a block.
+func (c *Canvas) Waste() int64 {
+	waste := int64(c.Root.Width) * int64(c.Root.Height)
+	for _, b := range c.Blocks {
+		waste -= int64(b.Width) * int64(b.Height)
+	}
+
+	return waste
+}
+
+// Fill returns the percentage of the canvas covered by blocks, 0 for an
+// empty canvas.
+func (c *Canvas) Fill() float64 {
+	area := int64(c.Root.Width) * int64(c.Root.Height)
+	if area == 0 {
+		return 0
+	}
+
+	return float64(area-c.Waste()) * 100 / float64(area)
 }
 
 func (c *Canvas) String() string {
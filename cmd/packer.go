@@ -1,67 +1,432 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/sspencer/packer"
 	"gopkg.in/alecthomas/kingpin.v2"
 )
 
 var (
-	app = kingpin.New("packer", "CSS Sprite generator")
-	//base64     = app.Flag("base64", "Create css with base64 encoded sprite.").Short('b').Bool()
+	app        = kingpin.New("packer", "CSS Sprite generator")
+	base64     = app.Flag("base64", "Create css with base64 encoded sprite.").Short('b').Bool()
 	format     = app.Flag("format", "Output format of the sprite (png or jpg)  [png].").Short('f').Default("png").String()
+	processor  = app.Flag("processor", "Stylesheet language, one of "+strings.Join(packer.Processors(), ", ")+" [css].").Short('P').Default("css").String()
+	tmplfile   = app.Flag("template", "Stylesheet template file, overrides the processor's template.").Short('t').String()
+	algorithm  = app.Flag("algorithm", "Packing algorithm, one of "+strings.Join(packer.AlgorithmNames(), ", ")+" [tightest of all].").Short('a').String()
+	binwidth   = app.Flag("bin-width", "Fixed width in px of a sprite that only grows downward [none].").Short('w').Int()
+	orient     = app.Flag("orientation", "Layout of the sprite, vertical, horizontal or binary-tree [binary-tree].").String()
+	sortby     = app.Flag("sort", "Order to pack images in, one of "+strings.Join(packer.LayoutNames(), ", ")+" [tightest of all].").String()
+	nosort     = app.Flag("no-sort", "Keep images in the order given.").Bool()
+	maxwidth   = app.Flag("max-width", "Maximum width in px of the sprite image, larger sprites are split into pages [none].").Int()
+	maxheight  = app.Flag("max-height", "Maximum height in px of the sprite image, larger sprites are split into pages [none].").Int()
+	pot        = app.Flag("power-of-two", "Make the sprite image dimensions powers of two.").Bool()
+	multiple   = app.Flag("multiple-of", "Make the sprite image dimensions multiples of this many px [1].").Int()
+	score      = app.Flag("score", "How to compare layouts, one of "+strings.Join(packer.ScorerNames(), ", ")+" [waste].").String()
+	optimize   = app.Flag("optimize", "Time to spend searching for a tighter layout, e.g. 10s [none].").Duration()
+	iterations = app.Flag("iterations", "Changes to try when searching for a tighter layout, the same every run [none].").Int()
+	layoutfile = app.Flag("layout-file", "JSON file of the last layout, images unchanged since keep their positions [none].").String()
+	repack     = app.Flag("repack-waste", "Fraction of a kept layout that may be empty before repacking, 0 to 1 [0, never].").Float64()
 	cssout     = app.Flag("css", "destination path for sprite image file").Default("css/").String()
 	imgout     = app.Flag("img", "destination path for sprite image file").Default("img/").String()
 	imgurl     = app.Flag("imgpath", "HTTP path to images on the web server").Short('i').Default("../img").String()
 	margin     = app.Flag("margin", "Margin in px between tiles.").Default("4").Short('m').Int()
+	spacing    = app.Flag("spacing", "Space in px between tiles, on top of their margins.").Int()
+	border     = app.Flag("border", "Space in px around the edge of the sprite, on top of the margins.").Int()
+	extrude    = app.Flag("extrude", "Repeat the edge pixels of every tile this many px outward.").Int()
+	trim       = app.Flag("trim", "Cut the transparent edges off every image, keeping its size in the css.").Bool()
+	threshold  = app.Flag("trim-threshold", "Alpha, 0 to 254, at or below which trimmed pixels count as transparent [0].").Int()
 	name       = app.Flag("name", "Name of sprite file without file extension (image and css).").Short('n').Default("sprite").String()
 	prefix     = app.Flag("prefix", "Prefix for the class name used in css.").Short('p').Default("sprite").String()
 	retina     = app.Flag("retina", "Generate retina and normal sprite. Source images must be in retina resolution.").Short('r').Bool()
+	hover      = app.Flag("hover", "Suffix of image names that become the :hover state of another image.").Default("_hover").String()
+	densities  = app.Flag("density", "Also draw the sprite at this pixel density, e.g. 1.5x or 3x. Repeatable.").Short('d').Strings()
+	source     = app.Flag("source-density", "Pixel density of the source images [highest density].").String()
 	background = app.Flag("background", "Background color of the sprite in hex (or 'transparent')").Default("transparent").String()
-	html       = app.Flag("html", "Output test HTML file to stdout").Bool()
-	showCSS    = app.Flag("show-css-template", "Print CSS Template to <stdout> and exit").Bool()
+	html       = app.Flag("html", "Write a test HTML page for the sprite, css processor only").Bool()
+	htmlout    = app.Flag("html-dir", "destination path for the test HTML page [css path]").String()
+	stats      = app.Flag("stats", "Print packing statistics as a table, or as JSON with --stats=json.").PlaceHolder("table").Enum("table", "json")
+	showCSS    = app.Flag("show-css-template", "Print the stylesheet template of the processor to <stdout> and exit").Bool()
 	showHTML   = app.Flag("show-html-template", "Print HTML Template to <stdout> and exit").Bool()
 
-	images = app.Arg("src", "source images").Strings()
+	images = app.Arg("src", "source images, or a single packer.toml configuration file").Strings()
 )
 
 func main() {
 	app.Version("0.0.1")
 	//kingpin.Parse()
-	kingpin.MustParse(app.Parse(os.Args[1:]))
+	args := statsArgs(os.Args[1:])
+	kingpin.MustParse(app.Parse(args))
 
 	if *showCSS {
-		fmt.Println(packer.CSSTemplate)
+		p, err := packer.LookupProcessor(*processor)
+		if err != nil {
+			app.Fatalf("%s\n", err)
+		}
+
+		fmt.Print(p.Template)
 		os.Exit(0)
 	}
 
 	if *showHTML {
-		fmt.Println(packer.HTMLTemplate)
+		fmt.Print(packer.HTMLTemplate)
 		os.Exit(0)
 	}
 
-	c := packer.Config{
-		Base64:     false, /**base64,*/
-		Retina:     *retina,
-		HTML:       *html,
-		CSSPath:    *cssout,
-		ImgPath:    *imgout,
-		ImgURL:     *imgurl,
-		Format:     *format,
-		Name:       *name,
-		Prefix:     *prefix,
-		Margin:     *margin,
-		Background: *background,
+	ds, src, err := parseDensities()
+	if err != nil {
+		app.Fatalf("%s\n", err)
+	}
+
+	c := &packer.Config{
+		Base64:        *base64,
+		Retina:        *retina,
+		HTML:          *html,
+		HTMLPath:      *htmlout,
+		CSSPath:       *cssout,
+		ImgPath:       *imgout,
+		ImgURL:        *imgurl,
+		Format:        *format,
+		Processor:     *processor,
+		Template:      *tmplfile,
+		Algorithm:     *algorithm,
+		BinWidth:      *binwidth,
+		Orientation:   *orient,
+		Sort:          *sortby,
+		NoSort:        *nosort,
+		MaxWidth:      *maxwidth,
+		MaxHeight:     *maxheight,
+		PowerOfTwo:    *pot,
+		SizeMultiple:  *multiple,
+		Score:         *score,
+		Optimize:      *optimize,
+		Iterations:    *iterations,
+		LayoutFile:    *layoutfile,
+		RepackWaste:   *repack,
+		Name:          *name,
+		Prefix:        *prefix,
+		Hover:         *hover,
+		Margin:        *margin,
+		Spacing:       *spacing,
+		Border:        *border,
+		Extrude:       *extrude,
+		Trim:          *trim,
+		TrimThreshold: *threshold,
+		Background:    *background,
+
+		Densities:     ds,
+		SourceDensity: src,
+	}
+
+	files := *images
+	if len(files) != 1 || strings.ToLower(filepath.Ext(files[0])) != ".toml" {
+		s, err := build(c, files)
+		if err != nil {
+			app.Fatalf("%s\n", err)
+		}
+
+		printStats([]*packer.Stats{s})
+		return
 	}
 
-	sprite, err := c.CreateSprite(*images)
+	configs, err := packer.LoadConfigs(files[0])
 	if err != nil {
 		app.Fatalf("%s\n", err)
 	}
 
-	if err := c.Save(sprite); err != nil {
-		app.Fatalf("%s\n", err)
+	// each sprite needs files of its own
+	set := flagsSet(args)
+	for _, flag := range []string{"name", "layout-file"} {
+		if set[flag] && len(configs) > 1 {
+			app.Fatalf("--%s cannot be used with a config file describing %d sprites\n", flag, len(configs))
+		}
+	}
+
+	// Each sprite is independent, so build them all at once.
+	ch := make(chan error)
+	all := make([]*packer.Stats, len(configs))
+	for i, c := range configs {
+		override(c, set)
+		go func(i int, c *packer.Config) {
+			files, err := c.Files()
+			if err == nil {
+				all[i], err = build(c, files)
+			}
+
+			if err != nil {
+				err = fmt.Errorf("%s: %s", c.Name, err)
+			}
+
+			ch <- err
+		}(i, c)
+	}
+
+	failed := false
+	for range configs {
+		if err := <-ch; err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+
+	printStats(all)
+}
+
+// build creates the sprite described by c from the image files and saves it.
+func build(c *packer.Config, files []string) (*packer.Stats, error) {
+	sprite, err := c.CreateSprite(files)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, w := range sprite.Warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", c.Name, w)
+	}
+
+	return sprite.Stats, c.Save(sprite)
+}
+
+// statsArgs lets --stats be given without a value, meaning --stats=table.
+func statsArgs(args []string) []string {
+	fixed := make([]string, len(args))
+	for i, arg := range args {
+		fixed[i] = arg
+		if arg == "--stats" && (i+1 == len(args) || (args[i+1] != "table" && args[i+1] != "json")) {
+			fixed[i] = "--stats=table"
+		}
+	}
+
+	return fixed
+}
+
+// printStats prints the statistics of the sprites to <stdout>, if asked to.
+func printStats(all []*packer.Stats) {
+	switch *stats {
+	case "json":
+		b, err := json.MarshalIndent(all, "", "  ")
+		if err != nil {
+			app.Fatalf("%s\n", err)
+		}
+
+		fmt.Println(string(b))
+	case "table":
+		for i, s := range all {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			fmt.Printf("%s: %d images on %d page(s), %dx%d, %d px wasted, %.1f%% filled\n", s.Name, s.Images, s.Pages, s.Width, s.Height, s.Waste, s.Fill)
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ALGORITHM\tLAYOUT\tSIZE\tWASTE\tFILL\tSCORE\t")
+			for _, c := range s.Candidates {
+				chosen := ""
+				if c.Chosen {
+					chosen = "*"
+				}
+
+				fmt.Fprintf(w, "%s\t%s\t%dx%d\t%d\t%.1f%%\t%v\t%s\n", c.Algorithm, c.Layout, c.Width, c.Height, c.Waste, c.Fill, c.Score, chosen)
+			}
+
+			w.Flush()
+		}
+	}
+}
+
+// parseDensities converts the density flags to numbers.
+func parseDensities() ([]float64, float64, error) {
+	ds := make([]float64, len(*densities))
+	for i, s := range *densities {
+		d, err := packer.ParseDensity(s)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ds[i] = d
+	}
+
+	if *source == "" {
+		return ds, 0, nil
+	}
+
+	src, err := packer.ParseDensity(*source)
+
+	return ds, src, err
+}
+
+// flagsSet returns the names of the flags given explicitly on the command line.
+func flagsSet(args []string) map[string]bool {
+	set := make(map[string]bool)
+	ctx, err := app.ParseContext(args)
+	if err != nil {
+		return set
+	}
+
+	for _, el := range ctx.Elements {
+		if f, ok := el.Clause.(*kingpin.FlagClause); ok {
+			set[f.Model().Name] = true
+		}
+	}
+
+	return set
+}
+
+// override replaces config file values with flags given on the command line.
+func override(c *packer.Config, set map[string]bool) {
+	if set["format"] {
+		c.Format = *format
+	}
+
+	if set["processor"] {
+		c.Processor = *processor
+	}
+
+	if set["algorithm"] {
+		c.Algorithm = *algorithm
+	}
+
+	if set["bin-width"] {
+		c.BinWidth = *binwidth
+	}
+
+	if set["orientation"] {
+		c.Orientation = *orient
+	}
+
+	if set["sort"] {
+		c.Sort = *sortby
+	}
+
+	if set["no-sort"] {
+		c.NoSort = *nosort
+	}
+
+	if set["max-width"] {
+		c.MaxWidth = *maxwidth
+	}
+
+	if set["max-height"] {
+		c.MaxHeight = *maxheight
+	}
+
+	if set["power-of-two"] {
+		c.PowerOfTwo = *pot
+	}
+
+	if set["multiple-of"] {
+		c.SizeMultiple = *multiple
+	}
+
+	if set["score"] {
+		c.Score = *score
+	}
+
+	if set["optimize"] {
+		c.Optimize = *optimize
+	}
+
+	if set["iterations"] {
+		c.Iterations = *iterations
+	}
+
+	if set["layout-file"] {
+		c.LayoutFile = *layoutfile
+	}
+
+	if set["repack-waste"] {
+		c.RepackWaste = *repack
+	}
+
+	if set["template"] {
+		c.Template = *tmplfile
+	}
+
+	if set["css"] {
+		c.CSSPath = *cssout
+	}
+
+	if set["img"] {
+		c.ImgPath = *imgout
+	}
+
+	if set["imgpath"] {
+		c.ImgURL = *imgurl
+	}
+
+	if set["margin"] {
+		c.Margin = *margin
+	}
+
+	if set["spacing"] {
+		c.Spacing = *spacing
+	}
+
+	if set["border"] {
+		c.Border = *border
+	}
+
+	if set["extrude"] {
+		c.Extrude = *extrude
+	}
+
+	if set["trim"] {
+		c.Trim = *trim
+	}
+
+	if set["trim-threshold"] {
+		c.TrimThreshold = *threshold
+	}
+
+	if set["name"] {
+		c.Name = *name
+	}
+
+	if set["prefix"] {
+		c.Prefix = *prefix
+	}
+
+	if set["hover"] {
+		c.Hover = *hover
+	}
+
+	if set["retina"] {
+		c.Retina = *retina
+	}
+
+	if set["density"] || set["source-density"] {
+		// already validated when building the default config
+		ds, src, _ := parseDensities()
+		if set["density"] {
+			c.Densities = ds
+		}
+
+		if set["source-density"] {
+			c.SourceDensity = src
+		}
+	}
+
+	if set["background"] {
+		c.Background = *background
+	}
+
+	if set["base64"] {
+		c.Base64 = *base64
+	}
+
+	if set["html"] {
+		c.HTML = *html
+	}
+
+	if set["html-dir"] {
+		c.HTMLPath = *htmlout
 	}
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/sspencer/packer"
+)
+
+func TestOverride(t *testing.T) {
+	args := []string{"--margin", "2", "--retina", "packer.toml"}
+	if _, err := app.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	// as read from a config file
+	c := packer.NewConfig()
+	c.Margin = 7
+	c.Prefix = "file"
+	c.Format = "jpg"
+
+	override(c, flagsSet(args))
+
+	if c.Margin != 2 || !c.Retina {
+		t.Errorf("flags given did not override the config file, margin %d retina %t", c.Margin, c.Retina)
+	}
+
+	// flags not given keep the file's values, not the flag defaults
+	if c.Prefix != "file" || c.Format != "jpg" {
+		t.Errorf("flags not given overrode the config file, prefix %q format %q", c.Prefix, c.Format)
+	}
+}
@@ -2,16 +2,22 @@ package packer
 
 import (
 	"bytes"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"image"
 	"image/draw"
 	"image/jpeg"
 	"image/png"
+	"io"
 	"io/ioutil"
+	"math"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/alecthomas/template"
 	"github.com/nfnt/resize"
@@ -28,48 +34,181 @@ type Config struct {
 	Base64     bool
 	Retina     bool
 	HTML       bool
+	HTMLPath   string
 	CSSPath    string
 	ImgPath    string
 	ImgURL     string
 	Format     string
+	Processor  string
+	Template   string
 	Name       string
 	Prefix     string
+	Hover      string
 	Margin     int
 	Background string
+	Includes   []string
+
+	// Spacing is the space, in 1x pixels, between neighbouring images on
+	// top of their margins.
+	Spacing int
+	// Border is the space, in 1x pixels, between the images and the edge
+	// of the sprite on top of their margins.
+	Border int
+	// Extrude repeats the edge pixels of every image this many 1x pixels
+	// outward, so texture filtering never samples a neighbour.  The
+	// stylesheet still points at the image itself.
+	Extrude int
+	// Trim cuts the transparent edges off every image, packing only the
+	// pixels with an alpha above TrimThreshold (0 to 254).  The stylesheet
+	// keeps the full size of each image, see SpriteImage.
+	Trim          bool
+	TrimThreshold int
+
+	// Densities lists the pixel densities to draw the sprite at, for
+	// example []float64{1, 2, 3}.  1x is always drawn, as is 2x when Retina
+	// is set.  Every density shares the same layout.
+	Densities []float64
+	// SourceDensity is the pixel density of the source images, the
+	// highest of Densities by default.  Files tagged with a density, like
+	// icon@2x.png, are only paired with icon.png when Densities, or
+	// Retina, draw more than 1x.
+	SourceDensity float64
+
+	// Algorithm is the name of the packing algorithm, such as
+	// "maxrects-bssf".  When empty every algorithm is tried and the
+	// tightest pack is kept.
+	Algorithm string
+	// BinWidth is the fixed width, in pixels of the densest image drawn, of
+	// a sprite that may only grow downward.  0 leaves the shape of the sprite up to the algorithm.
+	BinWidth int
+	// Orientation is "vertical" or "horizontal" to place the images in a
+	// single column or row, or "binary-tree" (the default) to pack them.
+	Orientation string
+	// Sort is the order images are packed in, the name of a registered
+	// layout such as width, height or input.  When empty all are tried.
+	Sort string
+	// NoSort keeps the images in the order given, the same as Sort "input".
+	NoSort bool
+	// MaxWidth and MaxHeight limit the size of the sprite image, in pixels
+	// of the densest image drawn.  A sprite that does not fit is split
+	// across several pages, Name-0, Name-1 and so on.  0 is unlimited.
+	MaxWidth  int
+	MaxHeight int
+	// PowerOfTwo makes both dimensions of the sprite image a power of two,
+	// for WebGL and game engine texture atlases.
+	PowerOfTwo bool
+	// SizeMultiple, when above 1, makes both dimensions of every sprite
+	// image a multiple of it, for example 4 for block compressed textures.
+	SizeMultiple int
+	// Score names the way candidate layouts are compared: waste (the
+	// default), square, width or aspect:<ratio>.
+	Score string
+	// Optimize, when above 0, is the time spent searching for a better
+	// layout than the one Fit finds, see FitOptimized.  Images kept in the
+	// order given, or placed in a strip, are never rearranged, and neither
+	// are images kept in place by LayoutFile.  How far the search gets
+	// depends on the speed of the machine, so unlike the rest of packer the
+	// output can differ from run to run.
+	Optimize time.Duration
+	// Iterations, when above 0, limits the search for a better layout to
+	// that many changes, stopping at whichever of it and Optimize comes
+	// first.  Set alone, it searches until done, and the output is the
+	// same from run to run.
+	Iterations int
+	// LayoutFile, when set, is a JSON file holding the layout of the last
+	// build.  Images unchanged since then keep their positions, so their
+	// background positions do not change, and Save writes the new layout
+	// to it.  See FitStable.
+	LayoutFile string
+	// RepackWaste is the fraction of the sprite, from 0 to 1, that may be
+	// left empty while keeping images in place, before they are all packed
+	// afresh.  0 never repacks.
+	RepackWaste float64
+
+	processor  *Processor
+	algorithms []Algorithm
+	layouts    []Layout
+	sizing     Sizing
+	scorer     Scorer
+	grid       int
 }
 
 // Sprite is result containing image(s) and stylesheet computed from packing blocks into a canvas.
 type Sprite struct {
 	Image       *image.RGBA
 	RetinaImage *image.RGBA
-	Stylesheet  string
+	// Scaled holds the images drawn at every density other than 1x,
+	// including RetinaImage, in increasing order of density.
+	Scaled     []*ScaledImage
+	Stylesheet string
+	// Warnings describes problems that did not stop the sprite being
+	// created, such as an icon.png that is not exactly half of icon@2x.png.
+	Warnings []string
+	// HTML is the test page for the sprite, only created when Config.HTML is
+	// set.  Save writes it to HTMLPath/Name.html.
+	HTML string
+	// Pages holds every image of a sprite split across several pages
+	// because it is larger than Config.MaxWidth or MaxHeight.  Image,
+	// RetinaImage and Scaled are then empty.
+	Pages []*Page
+	// Stats describes how well the images were packed.
+	Stats *Stats
+	// Layout is the layout Save writes to Config.LayoutFile, only set when
+	// there is a layout file and the sprite is not split across pages.
+	Layout *SavedLayout
+}
+
+// SavedLayout is the layout of a sprite kept in Config.LayoutFile, in 1x
+// pixels of the images themselves, without margins.
+type SavedLayout struct {
+	// Grid is the density grid the blocks were snapped to.  A layout saved
+	// with another grid is not reused.
+	Grid   int          `json:"grid"`
+	Blocks []SavedBlock `json:"blocks"`
+}
+
+// SavedBlock is the position of one image in a SavedLayout.
+type SavedBlock struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Trimmed is set when transparent edges were cut off the image, and
+	// only its trimmed pixels are in the sprite.  SpriteSourceSize is where
+	// they lie within the image, and SourceSize the size of the image.
+	Trimmed          bool       `json:"trimmed,omitempty"`
+	SpriteSourceSize *SavedRect `json:"spriteSourceSize,omitempty"`
+	SourceSize       *SavedSize `json:"sourceSize,omitempty"`
+}
+
+// SavedRect is a rectangle in a SavedLayout.
+type SavedRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
 }
 
-type spriteimage struct {
-	Name   string
-	Hover  string
-	X      int
-	Y      int
-	Width  int
-	Height int
+// SavedSize is a size in a SavedLayout.
+type SavedSize struct {
+	W int `json:"w"`
+	H int `json:"h"`
 }
 
-type stylesheet struct {
-	CSSPath string
-	ImgPath string
-	Retina  bool
-	ImgURL  string
-	Format  string
-	Prefix  string
-	Name    string
-	Images  []spriteimage
+// Page is one image of a sprite split across several pages.
+type Page struct {
+	Image *image.RGBA
+	// Scaled holds the page drawn at every density other than 1x.
+	Scaled []*ScaledImage
 }
 
-// CreateSprite creates a sprite and stylesheet for the config data.
+// CreateSprite creates a sprite and stylesheet for the config data.  Any
+// error returned is an *Error.
 func (c *Config) CreateSprite(files []string) (*Sprite, error) {
 
 	if len(files) == 0 {
-		return nil, fmt.Errorf("One or more images must be specified")
+		return nil, errorf(ConfigError, "", nil, "One or more images must be specified")
 	}
 	// Validate configuration parameters
 	if err := c.validate(); err != nil {
@@ -77,145 +216,695 @@ func (c *Config) CreateSprite(files []string) (*Sprite, error) {
 	}
 
 	// Convert file paths into image data
-	images, err := c.getImages(files)
+	images, names, err := c.getImages(files)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, src := range images {
+		warnings = append(warnings, src.mismatches()...)
+	}
+
+	sort.Strings(warnings)
+
+	previous, err := c.readLayout()
+	if err != nil {
+		return nil, err
+	}
+
+	if previous != nil && (c.Optimize > 0 || c.Iterations > 0) {
+		warnings = append(warnings, fmt.Sprintf("optimize is ignored while images keep their positions from %s", c.LayoutFile))
+	}
+
+	var trims map[string]trimBox
+	if c.Trim {
+		trims = c.trimImages(images)
+	}
+
+	// Pack once so every density shares the same layout
+	canvases, candidates, err := c.layout(images, names, previous)
+	if err != nil {
+		return nil, err
+	}
+
+	ss := c.stylesheet(canvases, trims)
+	sprite := &Sprite{Warnings: warnings, Stats: newStats(c.Name, canvases, candidates)}
+
+	if len(canvases) == 1 {
+		if c.LayoutFile != "" {
+			sprite.Layout = c.savedLayout(canvases[0], trims)
+		}
+
+		page, pi, err := c.drawPage(canvases[0], images, c.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		sprite.Image = page.Image
+		sprite.Scaled = page.Scaled
+		ss.Image = pi.Image
+		ss.ImgURI = pi.ImgURI
+		ss.Densities = pi.Densities
+
+		for i, di := range pi.Densities {
+			if di.Density == 2 {
+				sprite.RetinaImage = page.Scaled[i].Image
+				ss.Retina = true
+				ss.RetinaImage = di.Image
+				ss.RetinaURI = di.URI
+			}
+		}
+	} else {
+		for i, canvas := range canvases {
+			page, pi, err := c.drawPage(canvas, images, c.pageName(i))
+			if err != nil {
+				return nil, err
+			}
+
+			pi.Index = i
+			pi.Selector = pageSelector(ss.Images, i)
+
+			sprite.Pages = append(sprite.Pages, page)
+			ss.Pages = append(ss.Pages, *pi)
+		}
+	}
+
+	sprite.Stylesheet, sprite.HTML, err = c.render(ss)
 	if err != nil {
 		return nil, err
 	}
 
-	var retinaImage *image.RGBA
-	retinaImage = nil
+	return sprite, nil
+}
+
+// drawPage draws canvas at every density, naming its images after name.
+func (c *Config) drawPage(canvas *Canvas, images map[string]sourceImage, name string) (*Page, *PageImage, error) {
+	page := &Page{}
+	pi := &PageImage{
+		ImgPath: c.imagePath(name, ""),
+		Width:   canvas.Root.Width,
+		Height:  canvas.Root.Height,
+	}
+
+	prev := 1.0
 
-	//	var
-	if c.Retina {
-		_, retinaImage = c.createImage(images)
-		// resize images in image map
-		resized := make(map[string]*image.Image)
-		for name, img := range images {
-			w := (*img).Bounds().Max.X - (*img).Bounds().Min.X
-			m := resize.Resize(uint(w/2), 0, *img, resize.Lanczos3)
-			resized[name] = &m
+	for _, d := range c.Densities {
+		img := c.drawImage(canvas, images, d)
+		tag := ""
+		if d != 1 {
+			tag = densityTag(d)
 		}
 
-		images = resized
+		url := fmt.Sprintf("%s/%s%s.%s", c.ImgURL, name, tag, c.Format)
+
+		uri := ""
+		if c.Base64 {
+			var err error
+			if uri, err = c.dataURI(img); err != nil {
+				return nil, nil, err
+			}
+
+			url = uri
+		}
+
+		if d == 1 {
+			page.Image = img
+			pi.Image = url
+			pi.ImgURI = uri
+			continue
+		}
+
+		page.Scaled = append(page.Scaled, &ScaledImage{Density: d, Image: img})
+		pi.Densities = append(pi.Densities, DensityImage{
+			Density:  d,
+			Tag:      tag,
+			MinRatio: (prev + d) / 2,
+			Image:    url,
+			URI:      uri,
+			ImgPath:  c.imagePath(name, tag),
+		})
+
+		prev = d
 	}
 
-	css, normalImage := c.createImage(images)
+	return page, pi, nil
+}
+
+// pageSelector lists the classes of the images on the given page.
+func pageSelector(images []SpriteImage, page int) string {
+	var classes []string
+	for _, si := range images {
+		if si.Page == page {
+			classes = append(classes, "."+si.Name+si.Hover)
+		}
+	}
 
-	return &Sprite{Image: normalImage, RetinaImage: retinaImage, Stylesheet: css}, nil
+	return strings.Join(classes, ", ")
 }
 
-func (c *Config) createImage(images map[string]*image.Image) (string, *image.RGBA) {
+// layout packs a block for each image into canvases measured in 1x pixels,
+// more than one only when the sprite is larger than MaxWidth or MaxHeight.
+// Block sizes, and therefore positions, are snapped to the density grid.
+// Blocks are given in the order of names, the order the images were given.
+// They are packed with the spacing after them, then framed by the border.
+// The candidates describe the canvases tried, framed like the sprite, or
+// its pages when it was split.  Blocks
+// found in previous, the layout read from LayoutFile, keep their places.
+func (c *Config) layout(images map[string]sourceImage, names []string, previous Blocks) ([]*Canvas, []Candidate, error) {
+	pad := c.padding()
+	spacing := c.snap(c.Spacing)
+	border := c.snap(c.Border)
+
+	// the limits and bin width apply to the densest image, convert them to
+	// 1x pixels allowed by the sizing, within the border
+	densest := c.Densities[len(c.Densities)-1]
+	limit := func(px int) int {
+		if px == 0 {
+			return 0
+		}
+
+		return max(c.sizing.floor(int(float64(px)/densest))-border*2+spacing, 1)
+	}
+
+	maxW := limit(c.MaxWidth)
+	maxH := limit(c.MaxHeight)
+
+	binWidth := limit(c.BinWidth)
+
 	// create proxy 'block' for each image
-	blocks := make(Blocks, len(images))
-	i := 0
-	for name, image := range images {
-		img := *image
-		w := img.Bounds().Max.X - img.Bounds().Min.X + c.Margin*2
-		h := img.Bounds().Max.Y - img.Bounds().Min.Y + c.Margin*2
+	blocks := make(Blocks, len(names))
+	for i, name := range names {
+		master, d := images[name].master()
+		w := c.snapSource(master.img.Bounds().Dx(), d) + pad*2 + spacing
+		h := c.snapSource(master.img.Bounds().Dy(), d) + pad*2 + spacing
 		blocks[i] = &Block{Name: name, Width: w, Height: h}
 
-		i++
+		if c.BinWidth > 0 && w > binWidth {
+			return nil, nil, errorf(ConfigError, master.fn, nil, "Image is wider than the bin width of %dpx, %q", c.BinWidth, master.fn)
+		}
+
+		if (c.MaxWidth > 0 && w > maxW) || (c.MaxHeight > 0 && h > maxH) {
+			return nil, nil, errorf(ConfigError, master.fn, nil, "Image is larger than the maximum sprite size, %q", master.fn)
+		}
+	}
+
+	opts := FitOptions{
+		Algorithms: c.algorithms,
+		Layouts:    c.layouts,
+		Width:      binWidth,
+		Sizing:     c.sizing,
+		Scorer:     c.scorer,
+	}.defaults()
+
+	strip := c.Orientation == "vertical" || c.Orientation == "horizontal"
+
+	var canvas *Canvas
+	var tried []*Canvas
+	if previous != nil && !strip {
+		canvas = FitStable(blocks, StableOptions{FitOptions: opts, Previous: previous, MaxWaste: c.RepackWaste})
+	} else if (c.Optimize > 0 || c.Iterations > 0) && !strip && !c.NoSort && c.Sort != "input" {
+		canvas = FitOptimized(blocks, OptimizeOptions{FitOptions: opts, Duration: c.Optimize, Iterations: c.Iterations})
+	} else {
+		canvas, tried = fitCanvases(blocks, opts)
+	}
+
+	canvases := []*Canvas{canvas}
+	if (c.MaxWidth > 0 && canvas.Root.Width > maxW) || (c.MaxHeight > 0 && canvas.Root.Height > maxH) {
+		// the canvases tried were too large, so describe the pages instead
+		canvases = fitBins(blocks, maxW, maxH, opts)
+		tried = canvases
+	} else if tried == nil {
+		tried = canvases
 	}
 
-	canvas := Fit(blocks)
+	// frame every canvas tried, so the candidates agree with the sprite
+	candidates := make([]Candidate, len(tried))
+	for i, t := range tried {
+		c.frame(t, spacing, border)
+		t.Score = opts.Scorer.Score(t)
+		candidates[i] = t.Candidate()
+		candidates[i].Chosen = t == canvas || len(canvases) > 1
+	}
+
+	// the sprite is drawn framed, at every density
+	for _, canvas := range canvases {
+		if err := checkSize(scale(canvas.Root.Width, densest), scale(canvas.Root.Height, densest)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return canvases, candidates, nil
+}
+
+// frame moves the blocks of canvas, packed with spacing after each of them,
+// inside the border and sizes the canvas to hold them.
+func (c *Config) frame(canvas *Canvas, spacing, border int) {
+	canvas.Root.Width, canvas.Root.Height = 0, 0
+	for _, b := range canvas.Blocks {
+		b.X += border
+		b.Y += border
+		b.Width -= spacing
+		b.Height -= spacing
+
+		canvas.Root.Width = max(canvas.Root.Width, b.X+b.Width+border)
+		canvas.Root.Height = max(canvas.Root.Height, b.Y+b.Height+border)
+	}
+
+	c.sizing.padCanvas(canvas)
+}
+
+// padding returns the space, in 1x pixels, between the edge of a block and
+// its image, the margin and the extruded edge.
+func (c *Config) padding() int {
+	return c.snap(c.Margin) + c.snap(c.Extrude)
+}
+
+// readLayout returns the blocks of the layout saved in LayoutFile, or none
+// when there is no layout file yet, or it was saved with another grid.
+func (c *Config) readLayout() (Blocks, error) {
+	if c.LayoutFile == "" {
+		return nil, nil
+	}
+
+	b, err := ioutil.ReadFile(c.LayoutFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errorf(ConfigError, c.LayoutFile, err, "Could not read layout file, %q", c.LayoutFile)
+	}
+
+	var saved SavedLayout
+	if err = json.Unmarshal(b, &saved); err != nil {
+		return nil, errorf(ConfigError, c.LayoutFile, err, "Problem parsing layout file, %q", c.LayoutFile)
+	}
+
+	if saved.Grid != c.grid {
+		return nil, nil
+	}
+
+	// back to blocks as they are packed, see layout
+	pad := c.padding()
+	spacing := c.snap(c.Spacing)
+	offset := c.snap(c.Border) + pad
+
+	blocks := make(Blocks, len(saved.Blocks))
+	for i, sb := range saved.Blocks {
+		blocks[i] = &Block{Name: sb.Name, X: sb.X - offset, Y: sb.Y - offset, Width: sb.Width + pad*2 + spacing, Height: sb.Height + pad*2 + spacing}
+	}
+
+	return blocks, nil
+}
 
-	ss := stylesheet{
-		CSSPath: path.Join(c.CSSPath, fmt.Sprintf("%s.css", c.Name)),
-		ImgPath: path.Join(c.ImgPath, fmt.Sprintf("%s.%s", c.Name, c.Format)),
-		Retina:  c.Retina,
+// savedLayout describes canvas, and how its images were trimmed, for the
+// layout file.
+func (c *Config) savedLayout(canvas *Canvas, trims map[string]trimBox) *SavedLayout {
+	pad := c.padding()
+	saved := &SavedLayout{Grid: c.grid, Blocks: make([]SavedBlock, len(canvas.Blocks))}
+	for i, b := range canvas.Blocks {
+		saved.Blocks[i] = SavedBlock{Name: b.Name, X: b.X + pad, Y: b.Y + pad, Width: b.Width - pad*2, Height: b.Height - pad*2}
+		if t, ok := trims[b.Name]; ok {
+			saved.Blocks[i].Trimmed = true
+			saved.Blocks[i].SpriteSourceSize = &SavedRect{X: t.rect.Min.X, Y: t.rect.Min.Y, W: t.rect.Dx(), H: t.rect.Dy()}
+			saved.Blocks[i].SourceSize = &SavedSize{W: t.size.X, H: t.size.Y}
+		}
+	}
+
+	return saved
+}
+
+// stylesheet describes the 1x canvases for the stylesheet templates.  The
+// image of a sprite split across pages is described by the pages.  Trimmed
+// images keep their full size, positioned so their pixels stay in place.
+func (c *Config) stylesheet(canvases []*Canvas, trims map[string]trimBox) *Stylesheet {
+	ss := Stylesheet{
+		CSSPath: c.stylesheetFile(),
 		Format:  c.Format,
 		ImgURL:  c.ImgURL,
 		Name:    c.Name,
 		Prefix:  c.Prefix,
 	}
 
-	var sprites []spriteimage
+	if len(canvases) == 1 {
+		ss.ImgPath = c.imageFile("")
+		ss.RetinaImgPath = c.imageFile(retinaTag)
+		ss.Width = canvases[0].Root.Width
+		ss.Height = canvases[0].Root.Height
+	}
 
-	rgba := image.NewRGBA(image.Rect(0, 0, canvas.Root.Width, canvas.Root.Height))
-	draw.Draw(rgba, rgba.Bounds(), colorToUniform(c.Background), image.ZP, draw.Src)
+	var sprites []SpriteImage
+	pad := c.padding()
 
-	for _, b := range canvas.Blocks {
-		img, ok := images[b.Name]
-		if ok {
-			src := *img
-			x := b.X + c.Margin
-			y := b.Y + c.Margin
-			dp := image.Pt(x, y)
-			r := image.Rectangle{dp, dp.Add(image.Pt(b.Width, b.Height))}
-			draw.Draw(rgba, r, src, image.ZP, draw.Src)
-
-			hasHover := strings.HasSuffix(b.Name, hoverTrigger)
+	for page, canvas := range canvases {
+		for _, b := range canvas.Blocks {
+			hasHover := strings.HasSuffix(b.Name, c.Hover)
 			name := b.Name
 			if hasHover {
-				name = name[0 : len(name)-len(hoverTrigger)]
+				name = name[0 : len(name)-len(c.Hover)]
 			}
 
-			si := spriteimage{
+			si := SpriteImage{
 				Name:   fmt.Sprintf("%s_%s", c.Prefix, name),
-				X:      -x,
-				Y:      -y,
-				Width:  b.Width,
-				Height: b.Height,
+				Var:    strings.Replace(fmt.Sprintf("%s-%s", c.Prefix, b.Name), "_", "-", -1),
+				X:      -(b.X + pad),
+				Y:      -(b.Y + pad),
+				Width:  b.Width - pad*2,
+				Height: b.Height - pad*2,
+				Page:   page,
 			}
 
 			if hasHover {
 				si.Hover = hoverCSS
 			}
 
+			if c.Trim {
+				// a hover state trimmed differently must undo the padding
+				si.Padding = "0"
+			}
+
+			if t, ok := trims[b.Name]; ok {
+				si.Trimmed = true
+				si.TrimX, si.TrimY = t.rect.Min.X, t.rect.Min.Y
+				si.SourceWidth, si.SourceHeight = t.size.X, t.size.Y
+				si.Padding = fmt.Sprintf("%dpx %dpx %dpx %dpx", t.rect.Min.Y, t.size.X-t.rect.Max.X, t.size.Y-t.rect.Max.Y, t.rect.Min.X)
+			}
+
 			sprites = append(sprites, si)
 		}
 	}
 
 	ss.Images = sprites
 
+	return &ss
+}
+
+// drawImage draws the images into the canvas at density d.  An image file
+// given at density d is drawn as is, otherwise the image is scaled from its
+// highest density file.  That file is first padded to its snapped block
+// size, so scaling never has to stretch it by a fractional pixel.
+func (c *Config) drawImage(canvas *Canvas, images map[string]sourceImage, d float64) *image.RGBA {
+	rgba := c.createImage(canvas, d)
+	pad := c.padding()
+
+	for _, b := range canvas.Blocks {
+		files, ok := images[b.Name]
+		if ok {
+			w := b.Width - pad*2
+			h := b.Height - pad*2
+
+			var src image.Image
+			if f, ok := files[d]; ok {
+				src = f.img
+			} else {
+				master, md := files.master()
+				src = master.img
+				padded := image.NewRGBA(image.Rect(0, 0, scale(w, md), scale(h, md)))
+				draw.Draw(padded, src.Bounds().Sub(src.Bounds().Min), src, src.Bounds().Min, draw.Src)
+				src = resize.Resize(uint(scale(w, d)), uint(scale(h, d)), padded, resize.Lanczos3)
+			}
+
+			dp := image.Pt(scale(b.X+pad, d), scale(b.Y+pad, d))
+			r := image.Rectangle{dp, dp.Add(image.Pt(scale(w, d), scale(h, d)))}
+			draw.Draw(rgba, r, src, src.Bounds().Min, draw.Src)
+			extrude(rgba, r, scale(c.snap(c.Extrude), d))
+		}
+	}
+
+	return rgba
+}
+
+// extrude repeats the edge pixels of the image drawn in r n pixels outward,
+// the corner pixels filling the corners.
+func extrude(rgba *image.RGBA, r image.Rectangle, n int) {
+	if n == 0 || r.Empty() {
+		return
+	}
+
+	for i := 1; i <= n; i++ {
+		draw.Draw(rgba, image.Rect(r.Min.X-i, r.Min.Y, r.Min.X-i+1, r.Max.Y), rgba, r.Min, draw.Src)
+		draw.Draw(rgba, image.Rect(r.Max.X+i-1, r.Min.Y, r.Max.X+i, r.Max.Y), rgba, image.Pt(r.Max.X-1, r.Min.Y), draw.Src)
+	}
+
+	// the rows now include the extruded columns
+	r = image.Rect(r.Min.X-n, r.Min.Y, r.Max.X+n, r.Max.Y)
+	for i := 1; i <= n; i++ {
+		draw.Draw(rgba, image.Rect(r.Min.X, r.Min.Y-i, r.Max.X, r.Min.Y-i+1), rgba, r.Min, draw.Src)
+		draw.Draw(rgba, image.Rect(r.Min.X, r.Max.Y+i-1, r.Max.X, r.Max.Y+i), rgba, image.Pt(r.Min.X, r.Max.Y-1), draw.Src)
+	}
+}
+
+// createImage allocates the image for canvas at density d, filled with the
+// background color.  The root of the canvas is already padded to the sizing,
+// which scaling to any allowed density keeps.
+func (c *Config) createImage(canvas *Canvas, d float64) *image.RGBA {
+	rgba := image.NewRGBA(image.Rect(0, 0, scale(canvas.Root.Width, d), scale(canvas.Root.Height, d)))
+	draw.Draw(rgba, rgba.Bounds(), colorToUniform(c.Background), image.ZP, draw.Src)
+
+	return rgba
+}
+
+// render executes the stylesheet template, and optionally the HTML test template.
+func (c *Config) render(ss *Stylesheet) (string, string, error) {
+	src := c.processor.Template
+	if c.Template != "" {
+		b, err := ioutil.ReadFile(c.Template)
+		if err != nil {
+			return "", "", errorf(TemplateError, c.Template, err, "Could not read template, %q", c.Template)
+		}
+
+		src = string(b)
+	}
+
 	var doc bytes.Buffer
 	// CSS Template
-	tmpl, err := template.New("css").Parse(string(CSSTemplate))
+	tmpl, err := template.New("css").Parse(src)
 	if err != nil {
-		fmt.Fprintln(os.Stderr, err)
-		os.Exit(1)
+		return "", "", errorf(TemplateError, c.Template, err, "Problem parsing stylesheet template")
 	}
 
-	tmpl.Execute(&doc, &ss)
+	if err := tmpl.Execute(&doc, ss); err != nil {
+		return "", "", errorf(TemplateError, c.Template, err, "Problem executing stylesheet template")
+	}
 
-	// Optional Test template (to stdout)
-	if c.HTML {
-		tmpl, err := template.New("html").Parse(string(HTMLTemplate))
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			os.Exit(1)
+	if !c.HTML {
+		return doc.String(), "", nil
+	}
+
+	// Optional Test template, with file paths relative to the page
+	dir, err := filepath.Abs(c.htmlPath())
+	if err != nil {
+		return "", "", errorf(OutputError, c.htmlPath(), err, "Could not resolve HTML path")
+	}
+
+	hs := *ss
+	hs.Densities = append([]DensityImage{}, ss.Densities...)
+	paths := []*string{&hs.CSSPath, &hs.ImgPath, &hs.RetinaImgPath}
+	for i := range hs.Densities {
+		paths = append(paths, &hs.Densities[i].ImgPath)
+	}
+
+	hs.Pages = append([]PageImage{}, ss.Pages...)
+	for i := range hs.Pages {
+		page := &hs.Pages[i]
+		page.Densities = append([]DensityImage{}, page.Densities...)
+		paths = append(paths, &page.ImgPath)
+		for j := range page.Densities {
+			paths = append(paths, &page.Densities[j].ImgPath)
+		}
+	}
+
+	for _, p := range paths {
+		if *p == "" {
+			// no single image for a sprite split across pages
+			continue
 		}
 
-		tmpl.Execute(os.Stdout, &ss)
+		if *p, err = relativePath(dir, *p); err != nil {
+			return "", "", errorf(OutputError, *p, err, "Could not resolve path relative to HTML page")
+		}
 	}
 
-	return doc.String(), rgba
+	var page bytes.Buffer
+	tmpl, err = template.New("html").Parse(string(HTMLTemplate))
+	if err != nil {
+		return "", "", errorf(TemplateError, "", err, "Problem parsing HTML template")
+	}
+
+	if err := tmpl.Execute(&page, &hs); err != nil {
+		return "", "", errorf(TemplateError, "", err, "Problem executing HTML template")
+	}
+
+	return doc.String(), page.String(), nil
 }
 
 func (c *Config) String() string {
-	return fmt.Sprintf("CONFIG: base64=%t retina=%t html=%t css=%s img=%s imgurl=%s format=%s name=%s prefix=%s bg=%s margin=%d>",
+	return fmt.Sprintf("CONFIG: base64=%t retina=%t html=%t htmlpath=%s css=%s img=%s imgurl=%s format=%s densities=%v source=%v processor=%s algorithm=%s binwidth=%d orientation=%s sort=%s nosort=%t maxwidth=%d maxheight=%d pot=%t multiple=%d score=%s optimize=%v iterations=%d layoutfile=%s repackwaste=%v template=%s name=%s prefix=%s hover=%s bg=%s margin=%d spacing=%d border=%d extrude=%d trim=%t trimthreshold=%d>",
 		c.Base64,
 		c.Retina,
 		c.HTML,
+		c.HTMLPath,
 		c.CSSPath,
 		c.ImgPath,
 		c.ImgURL,
 		c.Format,
+		c.Densities,
+		c.SourceDensity,
+		c.Processor,
+		c.Algorithm,
+		c.BinWidth,
+		c.Orientation,
+		c.Sort,
+		c.NoSort,
+		c.MaxWidth,
+		c.MaxHeight,
+		c.PowerOfTwo,
+		c.SizeMultiple,
+		c.Score,
+		c.Optimize,
+		c.Iterations,
+		c.LayoutFile,
+		c.RepackWaste,
+		c.Template,
 		c.Name,
 		c.Prefix,
+		c.Hover,
 		c.Background,
-		c.Margin)
+		c.Margin,
+		c.Spacing,
+		c.Border,
+		c.Extrude,
+		c.Trim,
+		c.TrimThreshold)
 }
 
 // validate config parameters
 func (c *Config) validate() error {
 
 	if c.Margin < 0 || c.Margin > 100 {
-		return fmt.Errorf("margin must have a value between 0 and 100")
+		return errorf(ConfigError, "", nil, "margin must have a value between 0 and 100")
+	}
+
+	if c.Spacing < 0 || c.Spacing > 100 {
+		return errorf(ConfigError, "", nil, "spacing must have a value between 0 and 100")
+	}
+
+	if c.Border < 0 || c.Border > 100 {
+		return errorf(ConfigError, "", nil, "border must have a value between 0 and 100")
+	}
+
+	if c.Extrude < 0 || c.Extrude > 100 {
+		return errorf(ConfigError, "", nil, "extrude must have a value between 0 and 100")
+	}
+
+	if c.TrimThreshold < 0 || c.TrimThreshold > 254 {
+		return errorf(ConfigError, "", nil, "trim threshold must have a value between 0 and 254")
 	}
 
 	if c.Format != "jpg" && c.Format != "png" {
-		return fmt.Errorf("illegal option %q for format (only 'png' or 'jpg' allowed)", c.Format)
+		return errorf(ConfigError, "", nil, "illegal option %q for format (only 'png' or 'jpg' allowed)", c.Format)
+	}
+
+	if err := c.normalizeDensities(); err != nil {
+		return err
+	}
+
+	if c.Processor == "" {
+		c.Processor = "css"
+	}
+
+	p, err := LookupProcessor(c.Processor)
+	if err != nil {
+		return errorf(ConfigError, "", err, "illegal option %q for processor", c.Processor)
+	}
+
+	c.processor = p
+
+	// browsers only load css, so the test page cannot link anything else
+	if c.HTML && p.Extension != "css" {
+		return errorf(ConfigError, "", nil, "the HTML test page needs a css stylesheet, not %s", p.Extension)
+	}
+
+	c.algorithms = nil
+	if c.Algorithm != "" {
+		a, err := ParseAlgorithm(c.Algorithm)
+		if err != nil {
+			return errorf(ConfigError, "", err, "illegal option %q for algorithm", c.Algorithm)
+		}
+
+		if c.BinWidth > 0 && !a.FixedWidth() {
+			return errorf(ConfigError, "", nil, "algorithm %q cannot pack into a fixed bin width", c.Algorithm)
+		}
+
+		c.algorithms = []Algorithm{a}
+	}
+
+	if c.Optimize < 0 {
+		return errorf(ConfigError, "", nil, "optimize must not be negative")
+	}
+
+	if c.Iterations < 0 {
+		return errorf(ConfigError, "", nil, "iterations must not be negative")
+	}
+
+	if c.RepackWaste < 0 || c.RepackWaste > 1 {
+		return errorf(ConfigError, "", nil, "repack waste must be between 0 and 1")
+	}
+
+	if c.BinWidth < 0 {
+		return errorf(ConfigError, "", nil, "bin width must not be negative")
+	}
+
+	if c.MaxWidth < 0 || c.MaxHeight < 0 {
+		return errorf(ConfigError, "", nil, "maximum sprite size must not be negative")
+	}
+
+	if err := c.validateSizing(); err != nil {
+		return err
+	}
+
+	c.scorer = nil
+	if c.Score != "" {
+		s, err := ParseScorer(c.Score)
+		if err != nil {
+			return errorf(ConfigError, "", err, "illegal option %q for score", c.Score)
+		}
+
+		c.scorer = s
+	}
+
+	switch c.Orientation {
+	case "", "binary-tree":
+	case "vertical", "horizontal":
+		if c.Algorithm != "" {
+			return errorf(ConfigError, "", nil, "algorithm %q cannot be used with a %s orientation", c.Algorithm, c.Orientation)
+		}
+
+		if c.Orientation == "horizontal" && c.BinWidth > 0 {
+			return errorf(ConfigError, "", nil, "a horizontal orientation cannot have a fixed bin width")
+		}
+
+		c.algorithms = []Algorithm{AlgorithmVertical}
+		if c.Orientation == "horizontal" {
+			c.algorithms = []Algorithm{AlgorithmHorizontal}
+		}
+	default:
+		return errorf(ConfigError, "", nil, "illegal option %q for orientation (only 'vertical', 'horizontal' or 'binary-tree' allowed)", c.Orientation)
+	}
+
+	c.layouts = Layouts()
+	if c.NoSort {
+		c.layouts = []Layout{LayoutByInput}
+	} else if c.Sort != "" {
+		l, err := ParseLayout(c.Sort)
+		if err != nil {
+			return errorf(ConfigError, "", err, "illegal option %q for sort", c.Sort)
+		}
+
+		c.layouts = []Layout{l}
+	}
+
+	if c.Hover == "" {
+		c.Hover = hoverTrigger
 	}
 
 	if c.Format == "jpg" && strings.ToLower(c.Background) == "transparent" {
@@ -225,46 +914,204 @@ func (c *Config) validate() error {
 	return nil
 }
 
-// Save saves stylesheet and image(s) to disk.
+// validateSizing checks PowerOfTwo and SizeMultiple and works out the
+// sizing of the 1x canvas that keeps every density within the constraints.
+func (c *Config) validateSizing() error {
+	if c.SizeMultiple < 0 {
+		return errorf(ConfigError, "", nil, "size multiple must not be negative")
+	}
+
+	n := max(c.SizeMultiple, 1)
+	if c.PowerOfTwo && n&(n-1) != 0 {
+		return errorf(ConfigError, "", nil, "size multiple %d cannot be used with power of two sizes", n)
+	}
+
+	if c.PowerOfTwo {
+		for _, d := range c.Densities {
+			if f, e := math.Frexp(d); f != 0.5 || e < 1 {
+				return errorf(ConfigError, "", nil, "power of two sizes need power of two pixel densities, not %vx", d)
+			}
+		}
+	}
+
+	// The smallest multiple of n that is also a whole multiple of n at
+	// every density.  n times the density grid always is.
+	m := n
+	for ; m < n*c.grid; m += n {
+		whole := true
+		for _, d := range c.Densities {
+			v := float64(m) * d
+			if v != math.Floor(v) || int(v)%n != 0 {
+				whole = false
+				break
+			}
+		}
+
+		if whole {
+			break
+		}
+	}
+
+	c.sizing = Sizing{PowerOfTwo: c.PowerOfTwo, Multiple: m}
+
+	return nil
+}
+
+// Save saves stylesheet and image(s) to disk.  In Base64 mode the images
+// are already inlined in the stylesheet and are not written.  Any error
+// returned is an *Error.
 func (c *Config) Save(sprite *Sprite) error {
-	fn, err := filepath.Abs(path.Join(c.CSSPath, fmt.Sprintf("%s.css", c.Name)))
-	if err != nil {
+	if err := c.validate(); err != nil {
 		return err
 	}
 
+	fn, err := filepath.Abs(c.stylesheetFile())
+	if err != nil {
+		return errorf(OutputError, c.stylesheetFile(), err, "Could not resolve stylesheet path")
+	}
+
 	if err = ioutil.WriteFile(fn, []byte(sprite.Stylesheet), 0644); err != nil {
-		return err
+		return errorf(OutputError, fn, err, "Could not write stylesheet, %q", fn)
 	}
 
-	fn, err = filepath.Abs(path.Join(c.ImgPath, fmt.Sprintf("%s.%s", c.Name, c.Format)))
-	if err != nil {
-		return err
+	if sprite.HTML != "" {
+		fn = path.Join(c.htmlPath(), fmt.Sprintf("%s.html", c.Name))
+		if err = ioutil.WriteFile(fn, []byte(sprite.HTML), 0644); err != nil {
+			return errorf(OutputError, fn, err, "Could not write HTML page, %q", fn)
+		}
 	}
 
-	err = c.saveImage(fn, sprite.Image)
-	if err != nil {
+	if sprite.Layout != nil && c.LayoutFile != "" {
+		b, err := json.MarshalIndent(sprite.Layout, "", "  ")
+		if err == nil {
+			err = ioutil.WriteFile(c.LayoutFile, append(b, '\n'), 0644)
+		}
+
+		if err != nil {
+			return errorf(OutputError, c.LayoutFile, err, "Could not write layout file, %q", c.LayoutFile)
+		}
+	}
+
+	if c.Base64 {
+		return nil
+	}
+
+	if len(sprite.Pages) > 0 {
+		for i, page := range sprite.Pages {
+			if err = c.savePage(c.pageName(i), page.Image, page.Scaled); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return c.savePage(c.Name, sprite.Image, sprite.Scaled)
+}
+
+// savePage writes img and its scaled versions, naming them after name.
+func (c *Config) savePage(name string, img *image.RGBA, scaled []*ScaledImage) error {
+	if err := c.saveImage(c.imagePath(name, ""), img); err != nil {
 		return err
 	}
 
-	if sprite.RetinaImage != nil {
-		fn, err = filepath.Abs(path.Join(c.ImgPath, fmt.Sprintf("%s%s.%s", c.Name, retinaTag, c.Format)))
-		if err != nil {
+	for _, s := range scaled {
+		if err := c.saveImage(c.imagePath(name, densityTag(s.Density)), s.Image); err != nil {
 			return err
 		}
+	}
 
-		err = c.saveImage(fn, sprite.RetinaImage)
+	return nil
+}
+
+// stylesheetFile returns the path of the stylesheet, with the extension of its processor.
+func (c *Config) stylesheetFile() string {
+	return path.Join(c.CSSPath, fmt.Sprintf("%s.%s", c.Name, c.processor.Extension))
+}
+
+// imageFile returns the path of the sprite image, with tag appended to its name.
+func (c *Config) imageFile(tag string) string {
+	return c.imagePath(c.Name, tag)
+}
+
+// imagePath returns the path of the image called name, with tag appended.
+func (c *Config) imagePath(name, tag string) string {
+	return path.Join(c.ImgPath, fmt.Sprintf("%s%s.%s", name, tag, c.Format))
+}
+
+// pageName returns the name of page i of a sprite split across pages, e.g. "sprite-0".
+func (c *Config) pageName(i int) string {
+	return fmt.Sprintf("%s-%d", c.Name, i)
+}
+
+// htmlPath returns the directory of the HTML page, the stylesheet directory by default.
+func (c *Config) htmlPath() string {
+	if c.HTMLPath == "" {
+		return c.CSSPath
+	}
+
+	return c.HTMLPath
+}
+
+// relativePath returns the slash separated path of fn relative to the directory dir.
+func relativePath(dir, fn string) (string, error) {
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(dir, abs)
+	if err != nil {
+		return "", err
 	}
 
-	return err
+	return filepath.ToSlash(rel), nil
 }
 
 // save given image to disk
 func (c *Config) saveImage(fn string, img *image.RGBA) error {
-	w, _ := os.Create(fn)
-	defer w.Close()
+	fn, err := filepath.Abs(fn)
+	if err != nil {
+		return errorf(OutputError, fn, err, "Could not resolve image path")
+	}
+
+	w, err := os.Create(fn)
+	if err != nil {
+		return errorf(OutputError, fn, err, "Could not create image, %q", fn)
+	}
+
+	if err = c.encodeImage(w, img); err != nil {
+		w.Close()
+		return errorf(OutputError, fn, err, "Problem encoding image, %q", fn)
+	}
+
+	if err = w.Close(); err != nil {
+		return errorf(OutputError, fn, err, "Could not write image, %q", fn)
+	}
+
+	return nil
+}
+
+// encode image in the configured format
+func (c *Config) encodeImage(w io.Writer, img *image.RGBA) error {
 	if c.Format == "png" {
 		return png.Encode(w, img)
 	}
 
 	return jpeg.Encode(w, img, nil)
 }
+
+// dataURI encodes image as a base64 data URI for inlining in a stylesheet.
+func (c *Config) dataURI(img *image.RGBA) (string, error) {
+	var buf bytes.Buffer
+	if err := c.encodeImage(&buf, img); err != nil {
+		return "", errorf(OutputError, "", err, "Problem encoding image")
+	}
+
+	mime := "image/png"
+	if c.Format == "jpg" {
+		mime = "image/jpeg"
+	}
+
+	return fmt.Sprintf("data:%s;base64,%s", mime, base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
@@ -0,0 +1,566 @@
+package packer
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempDir creates a temporary directory, returning it and a function that
+// removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "packer")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writePNG writes img to the png file called name in dir, returning its path.
+func writePNG(t *testing.T, dir, name string, img image.Image) string {
+	fn := filepath.Join(dir, name)
+	f, err := os.Create(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer f.Close()
+
+	if err = png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+
+	return fn
+}
+
+// writeImages writes n png files of a few repeated sizes, so packing has
+// plenty of ties to break, and returns their paths.
+func writeImages(t *testing.T, dir string, n int) []string {
+	var files []string
+	for i := 0; i < n; i++ {
+		img := image.NewRGBA(image.Rect(0, 0, 8+(i%4)*6, 8+(i%3)*5))
+		for p := range img.Pix {
+			img.Pix[p] = uint8(i * 37)
+		}
+
+		img.Set(0, 0, color.RGBA{255, 0, 0, 255})
+		files = append(files, writePNG(t, dir, fmt.Sprintf("icon%02d.png", i), img))
+	}
+
+	return files
+}
+
+// createSprite creates a sprite from files with a NewConfig changed by
+// configure, failing the test on any error.
+func createSprite(t *testing.T, files []string, configure func(c *Config)) (*Config, *Sprite) {
+	c := NewConfig()
+	configure(c)
+
+	sprite, err := c.CreateSprite(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return c, sprite
+}
+
+func TestCreateSpriteDeterministic(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 30)
+
+	var first string
+	for i := 0; i < 25; i++ {
+		_, sprite := createSprite(t, files, func(c *Config) {
+			c.Base64 = true
+			c.Densities = []float64{1.5, 2}
+		})
+
+		// the base64 images are part of the stylesheet
+		if i == 0 {
+			first = sprite.Stylesheet
+		} else if sprite.Stylesheet != first {
+			t.Fatalf("run %d created a different sprite", i)
+		}
+	}
+}
+
+func TestCreateSpriteLayoutFile(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 12)
+
+	c, first := createSprite(t, files[:10], func(c *Config) {
+		c.Base64 = true
+		c.CSSPath = dir
+		c.LayoutFile = filepath.Join(dir, "sprite.json")
+	})
+
+	if err := c.Save(first); err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := c.CreateSprite(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(second.Layout.Blocks) != len(files) {
+		t.Fatalf("layout has %d of %d images", len(second.Layout.Blocks), len(files))
+	}
+
+	moved := make(map[string]SavedBlock)
+	for _, b := range second.Layout.Blocks {
+		moved[b.Name] = b
+	}
+
+	for _, b := range first.Layout.Blocks {
+		if moved[b.Name] != b {
+			t.Errorf("image moved from %v to %v", b, moved[b.Name])
+		}
+	}
+
+	if len(second.Warnings) != 0 {
+		t.Errorf("unexpected warnings %v", second.Warnings)
+	}
+
+	// the images kept in place are not rearranged by optimizing
+	c.Optimize = time.Millisecond
+	third, err := c.CreateSprite(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(third.Warnings) != 1 || !strings.Contains(third.Warnings[0], "optimize is ignored") {
+		t.Errorf("optimizing with a layout file warned %v", third.Warnings)
+	}
+}
+
+func TestCreateSpriteSpacing(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 6)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Margin = 0
+		c.Spacing = 2
+		c.Border = 3
+		c.Extrude = 1
+		c.LayoutFile = filepath.Join(dir, "sprite.json")
+	})
+
+	bounds := sprite.Image.Bounds()
+	blocks := sprite.Layout.Blocks
+	for i, a := range blocks {
+		if a.X < 4 || a.Y < 4 || a.X+a.Width > bounds.Dx()-4 || a.Y+a.Height > bounds.Dy()-4 {
+			t.Errorf("image %v is within the border of the %v sprite", a, bounds)
+		}
+
+		// the red top left pixel is extruded into the corner
+		if r, g, _, _ := sprite.Image.At(a.X-1, a.Y-1).RGBA(); r != 0xffff || g != 0 {
+			t.Errorf("image %v is not extruded", a)
+		}
+
+		for _, b := range blocks[i+1:] {
+			// 2 px of spacing and 1 px of extruded edge on each image
+			apart := a.X+a.Width+4 <= b.X || b.X+b.Width+4 <= a.X || a.Y+a.Height+4 <= b.Y || b.Y+b.Height+4 <= a.Y
+			if !apart {
+				t.Errorf("images %v and %v are too close", a, b)
+			}
+		}
+	}
+
+	if len(blocks) != len(files) || blocks[0].Width < 8 || blocks[0].Width > 8+3*6 {
+		t.Errorf("layout %v does not hold the images themselves", blocks)
+	}
+}
+
+func TestValidateFrame(t *testing.T) {
+	for _, tc := range []struct {
+		set  func(c *Config)
+		want string
+	}{
+		{func(c *Config) { c.Spacing = -1 }, "spacing must have a value between 0 and 100"},
+		{func(c *Config) { c.Border = 101 }, "border must have a value between 0 and 100"},
+		{func(c *Config) { c.Extrude = -1 }, "extrude must have a value between 0 and 100"},
+	} {
+		c := NewConfig()
+		tc.set(c)
+		if err := c.validate(); err == nil || err.Error() != tc.want {
+			t.Errorf("validate returned %v, not %q", err, tc.want)
+		}
+	}
+}
+
+func TestCreateSpriteTrim(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	// a 20x16 image with 10x6 opaque pixels at 4, 3 and a faint pixel at 0, 0
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 16))
+	for y := 3; y < 9; y++ {
+		for x := 4; x < 14; x++ {
+			img.Set(x, y, color.NRGBA{0, 0, 255, 255})
+		}
+	}
+
+	img.Set(0, 0, color.NRGBA{0, 0, 255, 10})
+	fn := writePNG(t, dir, "icon.png", img)
+
+	for _, tc := range []struct {
+		threshold int
+		trim      SavedRect
+	}{
+		{0, SavedRect{0, 0, 14, 9}},
+		{10, SavedRect{4, 3, 10, 6}},
+	} {
+		_, sprite := createSprite(t, []string{fn}, func(c *Config) {
+			c.Base64 = true
+			c.Margin = 0
+			c.Trim = true
+			c.TrimThreshold = tc.threshold
+			c.LayoutFile = filepath.Join(dir, "sprite.json")
+		})
+
+		b := sprite.Layout.Blocks[0]
+		if !b.Trimmed || *b.SpriteSourceSize != tc.trim || *b.SourceSize != (SavedSize{20, 16}) || b.Width != tc.trim.W || b.Height != tc.trim.H {
+			t.Errorf("threshold %d trimmed to %v %v %v, not %v", tc.threshold, b, b.SpriteSourceSize, b.SourceSize, tc.trim)
+		}
+
+		if bounds := sprite.Image.Bounds(); bounds.Dx() != tc.trim.W || bounds.Dy() != tc.trim.H {
+			t.Errorf("threshold %d made a %v sprite", tc.threshold, bounds)
+		}
+
+		want := fmt.Sprintf("width: %dpx;\n  height: %dpx;\n  padding: %dpx %dpx %dpx %dpx;", tc.trim.W, tc.trim.H, tc.trim.Y, 20-tc.trim.X-tc.trim.W, 16-tc.trim.Y-tc.trim.H, tc.trim.X)
+		if !strings.Contains(sprite.Stylesheet, want) {
+			t.Errorf("threshold %d stylesheet lacks %q:\n%s", tc.threshold, want, sprite.Stylesheet)
+		}
+	}
+}
+
+func TestCreateSpriteTrimNeighbours(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	// opaque boxes of different colours and places in transparent images
+	var files []string
+	sources := make(map[string]image.Image)
+	for i := 0; i < 6; i++ {
+		img := image.NewNRGBA(image.Rect(0, 0, 20, 16))
+		for y := i; y < i+5+i%3; y++ {
+			for x := 2 * i; x < 2*i+4+i%2; x++ {
+				img.Set(x, y, color.NRGBA{uint8(40 * i), 200, 0, 255})
+			}
+		}
+
+		name := fmt.Sprintf("icon%d", i)
+		sources["sprite_"+name] = img
+		files = append(files, writePNG(t, dir, name+".png", img))
+	}
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Margin = 0
+		c.Trim = true
+	})
+
+	rules := regexp.MustCompile(`\.(\w+) {\n  background-position: (-?\d+)px (-?\d+)px;\n  width: (\d+)px;\n  height: (\d+)px;\n  padding: (\d+)px (\d+)px (\d+)px (\d+)px;\n  box-sizing: content-box;\n  background-origin: content-box;\n  background-clip: content-box;`).FindAllStringSubmatch(sprite.Stylesheet, -1)
+	if len(rules) != len(files) {
+		t.Fatalf("stylesheet has %d of %d padded images:\n%s", len(rules), len(files), sprite.Stylesheet)
+	}
+
+	for _, rule := range rules {
+		var v [8]int
+		for i := range v {
+			v[i], _ = strconv.Atoi(rule[i+2])
+		}
+
+		x, y, w, h, top, right, bottom, left := v[0], v[1], v[2], v[3], v[4], v[5], v[6], v[7]
+		if w+left+right != 20 || h+top+bottom != 16 {
+			t.Errorf("%s is %dx%d, not 20x16", rule[1], w+left+right, h+top+bottom)
+			continue
+		}
+
+		// draw the element as a browser would, the background only shows
+		// in the content box, so every pixel must be the image's own
+		src := sources[rule[1]]
+		for py := 0; py < 16; py++ {
+			for px := 0; px < 20; px++ {
+				var got color.Color = color.NRGBA{}
+				if cx, cy := px-left, py-top; cx >= 0 && cy >= 0 && cx < w && cy < h {
+					got = sprite.Image.At(cx-x, cy-y)
+				}
+
+				if !sameColor(got, src.At(px, py)) {
+					t.Fatalf("%s shows %v at %d, %d, not %v", rule[1], got, px, py, src.At(px, py))
+				}
+			}
+		}
+	}
+}
+
+// sameColor reports whether a and b are the same colour, or both transparent.
+func sameColor(a, b color.Color) bool {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	if aa == 0 && ba == 0 {
+		return true
+	}
+
+	return ar == br && ag == bg && ab == bb && aa == ba
+}
+
+func TestCreateSpriteStats(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 12)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Border = 3
+	})
+
+	s := sprite.Stats
+	chosen := 0
+	for _, cand := range s.Candidates {
+		if cand.Chosen {
+			chosen++
+			if cand.Width != s.Width || cand.Height != s.Height || cand.Waste != s.Waste {
+				t.Errorf("chosen candidate %v does not match the %dx%d sprite", cand, s.Width, s.Height)
+			}
+		}
+	}
+
+	if chosen != 1 || len(s.Candidates) < 2 {
+		t.Errorf("%d of %d candidates were chosen", chosen, len(s.Candidates))
+	}
+
+	// split across pages, the candidates are the pages
+	_, sprite = createSprite(t, files, func(c *Config) {
+		c.Border = 3
+		c.MaxWidth = 60
+		c.MaxHeight = 60
+	})
+
+	s = sprite.Stats
+	if s.Pages < 2 || len(s.Candidates) != s.Pages {
+		t.Fatalf("%d pages have %d candidates", s.Pages, len(s.Candidates))
+	}
+
+	for _, cand := range s.Candidates {
+		if !cand.Chosen || cand.Width > 60 || cand.Height > 60 {
+			t.Errorf("page candidate %v is not a chosen page", cand)
+		}
+	}
+}
+
+func TestCreateSpriteHTML(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 3)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.HTML = true
+		c.CSSPath = filepath.Join(dir, "css")
+		c.ImgPath = filepath.Join(dir, "img")
+	})
+
+	if !strings.Contains(sprite.HTML, "<link href='sprite.css'") {
+		t.Errorf("test page does not link the stylesheet:\n%s", sprite.HTML)
+	}
+
+	// browsers cannot load the stylesheets of the other processors
+	for _, p := range []string{"scss", "sass", "less", "stylus"} {
+		c := NewConfig()
+		c.HTML = true
+		c.Processor = p
+
+		_, err := c.CreateSprite(files)
+		if e, ok := err.(*Error); !ok || e.Kind != ConfigError {
+			t.Errorf("a test page for %s returned %v", p, err)
+		}
+	}
+}
+
+func TestCreateSpriteBase64(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 4)
+	out := filepath.Join(dir, "out")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	c, sprite := createSprite(t, files, func(c *Config) {
+		c.Base64 = true
+		c.Retina = true
+		c.CSSPath = out
+		c.ImgPath = out
+	})
+
+	if sprite.RetinaImage == nil {
+		t.Fatal("no retina image was drawn")
+	}
+
+	// both images are inlined in the stylesheet, each only once
+	for _, img := range []struct {
+		tag   string
+		image *image.RGBA
+	}{
+		{"1x", sprite.Image},
+		{"2x", sprite.RetinaImage},
+	} {
+		uri, err := c.dataURI(img.image)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !strings.HasPrefix(uri, "data:image/png;base64,") {
+			t.Errorf("%s image is inlined as %.40q", img.tag, uri)
+		}
+
+		if n := strings.Count(sprite.Stylesheet, fmt.Sprintf("url(%s)", uri)); n != 1 {
+			t.Errorf("stylesheet inlines the %s image %d times", img.tag, n)
+		}
+	}
+
+	if n := strings.Count(sprite.Stylesheet, "data:image/png;base64,"); n != 2 {
+		t.Errorf("stylesheet has %d data URIs for 2 images", n)
+	}
+
+	if err := c.Save(sprite); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the stylesheet is written
+	written, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(written) != 1 || written[0].Name() != "sprite.css" {
+		for _, fi := range written {
+			t.Errorf("Save wrote %s", fi.Name())
+		}
+	}
+}
+
+func TestCreateSpriteTemplate(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 2)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Template = writeTemplate(t, dir, "{{.Prefix}} has {{len .Images}} images")
+	})
+
+	if sprite.Stylesheet != "sprite has 2 images" {
+		t.Errorf("custom template wrote %q", sprite.Stylesheet)
+	}
+
+	for _, tc := range []struct {
+		name, src string
+	}{
+		{"missing", ""},
+		{"parse", "{{range .Images}}"},
+		{"execute", "{{.Missing}}"},
+	} {
+		c := NewConfig()
+		c.Template = filepath.Join(dir, tc.name+".tmpl")
+		if tc.src != "" {
+			c.Template = writeTemplate(t, dir, tc.src)
+		}
+
+		_, err := c.CreateSprite(files)
+		if e, ok := err.(*Error); !ok || e.Kind != TemplateError || e.Path != c.Template {
+			t.Errorf("%s template returned %v", tc.name, err)
+		}
+	}
+}
+
+// writeTemplate writes a stylesheet template holding src to dir and returns
+// its path.
+func writeTemplate(t *testing.T, dir, src string) string {
+	fn := filepath.Join(dir, "sprite.tmpl")
+	if err := ioutil.WriteFile(fn, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fn
+}
+
+func TestCreateSpriteRetina(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 5)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Retina = true
+	})
+
+	b := sprite.Image.Bounds()
+	if rb := sprite.RetinaImage.Bounds(); rb.Dx() != b.Dx()*2 || rb.Dy() != b.Dy()*2 {
+		t.Errorf("retina image is %v, not twice %v", rb, b)
+	}
+
+	for _, want := range []string{
+		"background-image: url(../img/sprite.png);",
+		"background-image: -webkit-image-set(url(../img/sprite.png) 1x, url(../img/sprite@2x.png) 2x);",
+		"background-image: image-set(url(../img/sprite.png) 1x, url(../img/sprite@2x.png) 2x);",
+		fmt.Sprintf("background-size: %dpx %dpx;", b.Dx(), b.Dy()),
+		"@media (-webkit-min-device-pixel-ratio: 1.5), (min-resolution: 1.5dppx) {\n  .sprite {\n    background-image: url(../img/sprite@2x.png);",
+	} {
+		if !strings.Contains(sprite.Stylesheet, want) {
+			t.Errorf("retina stylesheet lacks %q:\n%s", want, sprite.Stylesheet)
+		}
+	}
+
+	// without retina the 1x image stands alone
+	_, sprite = createSprite(t, files, func(c *Config) {})
+	for _, unwanted := range []string{"image-set", "background-size", "@media", "@2x"} {
+		if strings.Contains(sprite.Stylesheet, unwanted) {
+			t.Errorf("stylesheet has %q without retina:\n%s", unwanted, sprite.Stylesheet)
+		}
+	}
+}
+
+func TestCreateSpriteBinWidth(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 8)
+
+	// the bin width is measured in pixels of the densest image
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Retina = true
+		c.BinWidth = 60
+	})
+
+	if w := sprite.RetinaImage.Bounds().Dx(); w > 60 {
+		t.Errorf("2x image is %dpx wide, wider than the 60px bin", w)
+	}
+
+	// the sources are drawn at 2x, so the 26px image needs 26px
+	c := NewConfig()
+	c.Retina = true
+	c.BinWidth = 24
+	if _, err := c.CreateSprite(files); err == nil || !strings.Contains(err.Error(), "wider than the bin width") {
+		t.Errorf("image wider than the bin gave %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package packer
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScaledImage is a sprite image drawn at a pixel density other than 1x.
+type ScaledImage struct {
+	Density float64
+	Image   *image.RGBA
+}
+
+// ParseDensity converts a pixel density such as "2x", "1.5x" or "3" to a number.
+func ParseDensity(s string) (float64, error) {
+	d, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(s), "x"), 64)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("illegal pixel density %q", s)
+	}
+
+	return d, nil
+}
+
+// densityTag returns the suffix added to the name of an image drawn at density d, e.g. "@2x".
+func densityTag(d float64) string {
+	return fmt.Sprintf("@%sx", strconv.FormatFloat(d, 'f', -1, 64))
+}
+
+// scale multiplies a length in pixels by density d, rounding to the nearest pixel.
+func scale(v int, d float64) int {
+	return int(math.Floor(float64(v)*d + 0.5))
+}
+
+// normalizeDensities sorts and removes duplicates from densities, making
+// sure 1x (and 2x in retina mode) are always included.
+func (c *Config) normalizeDensities() error {
+	densities := append([]float64{1}, c.Densities...)
+	if c.Retina {
+		densities = append(densities, 2)
+	}
+
+	sort.Float64s(densities)
+
+	c.Densities = densities[:0]
+	for _, d := range densities {
+		if d < 1 || math.IsInf(d, 0) || math.IsNaN(d) {
+			return errorf(ConfigError, "", nil, "illegal pixel density %v", d)
+		}
+
+		if len(c.Densities) == 0 || c.Densities[len(c.Densities)-1] != d {
+			c.Densities = append(c.Densities, d)
+		}
+	}
+
+	// unless stated otherwise, source images have the highest density
+	if c.SourceDensity == 0 {
+		c.SourceDensity = c.Densities[len(c.Densities)-1]
+	}
+
+	if c.SourceDensity < 0 {
+		return errorf(ConfigError, "", nil, "illegal source pixel density %v", c.SourceDensity)
+	}
+
+	c.grid = gridSize(append([]float64{c.SourceDensity}, c.Densities...))
+
+	return nil
+}
+
+// gridSize returns the smallest length n, in 1x pixels, for which n times
+// every density is a whole number of pixels.  Laying out blocks on a grid of
+// this size keeps every position and size an exact multiple of the scale
+// factor at every density, so no density gets blurry edges from rounding.
+func gridSize(densities []float64) int {
+	const maxGrid = 100
+	for n := 1; n < maxGrid; n++ {
+		whole := true
+		for _, d := range densities {
+			v := float64(n) * d
+			if math.Abs(v-math.Floor(v+0.5)) > 1e-9 {
+				whole = false
+				break
+			}
+		}
+
+		if whole {
+			return n
+		}
+	}
+
+	// no reasonable grid, fall back to rounding
+	return 1
+}
+
+// snap rounds a length in 1x pixels up to a multiple of the grid size.
+func (c *Config) snap(v int) int {
+	return (v + c.grid - 1) / c.grid * c.grid
+}
+
+// snapSource converts a length in pixels at density d to 1x pixels, rounded
+// up to a multiple of the grid size.
+func (c *Config) snapSource(v int, d float64) int {
+	cell := float64(c.grid) * d
+	return int(math.Ceil(float64(v)/cell-1e-9)) * c.grid
+}
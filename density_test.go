@@ -0,0 +1,144 @@
+package packer
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseDensity(t *testing.T) {
+	for s, want := range map[string]float64{
+		"1":     1,
+		"2x":    2,
+		"1.5x":  1.5,
+		" 3x ":  3,
+		"1.25x": 1.25,
+	} {
+		if d, err := ParseDensity(s); err != nil || d != want {
+			t.Errorf("ParseDensity(%q) returned %v, %v, not %v", s, d, err, want)
+		}
+	}
+
+	for _, s := range []string{"", "x", "0x", "-2x", "fast", "2xx"} {
+		if d, err := ParseDensity(s); err == nil {
+			t.Errorf("ParseDensity(%q) accepted %v", s, d)
+		}
+	}
+}
+
+func TestGridSize(t *testing.T) {
+	for _, tc := range []struct {
+		densities []float64
+		want      int
+	}{
+		{[]float64{1}, 1},
+		{[]float64{1, 2}, 1},
+		{[]float64{1, 3}, 1},
+		{[]float64{1, 1.5}, 2},
+		{[]float64{1, 1.5, 3}, 2},
+		{[]float64{1, 1.5, 2.5}, 2},
+		{[]float64{1, 1.25}, 4},
+		{[]float64{1, 1.5, 1.25}, 4},
+	} {
+		if got := gridSize(tc.densities); got != tc.want {
+			t.Errorf("gridSize(%v) is %d, not %d", tc.densities, got, tc.want)
+		}
+	}
+}
+
+func TestSnap(t *testing.T) {
+	c := &Config{grid: 2}
+	for v, want := range map[int]int{0: 0, 1: 2, 2: 2, 5: 6} {
+		if got := c.snap(v); got != want {
+			t.Errorf("snap(%d) on a grid of 2 is %d, not %d", v, got, want)
+		}
+	}
+
+	// source pixels at density d, snapped up to whole cells of 1x pixels
+	for _, tc := range []struct {
+		v    int
+		d    float64
+		want int
+	}{
+		{0, 1.5, 0},
+		{3, 1.5, 2},
+		{4, 1.5, 4},
+		{6, 1.5, 4},
+		{7, 1.5, 6},
+		{6, 3, 2},
+		{7, 3, 4},
+		{5, 1, 6},
+	} {
+		if got := c.snapSource(tc.v, tc.d); got != tc.want {
+			t.Errorf("snapSource(%d, %v) on a grid of 2 is %d, not %d", tc.v, tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestCreateSpriteDensities(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 7)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Densities = []float64{1.5, 3}
+	})
+
+	if len(sprite.Scaled) != 2 {
+		t.Fatalf("%d scaled images were drawn, not 2", len(sprite.Scaled))
+	}
+
+	// every density is drawn from the 1x layout
+	b := sprite.Image.Bounds()
+	for _, s := range sprite.Scaled {
+		if sb := s.Image.Bounds(); sb.Dx() != scale(b.Dx(), s.Density) || sb.Dy() != scale(b.Dy(), s.Density) {
+			t.Errorf("%vx image is %v, not %v scaled by %v", s.Density, sb, b, s.Density)
+		}
+	}
+
+	for _, want := range []string{
+		"image-set(url(../img/sprite.png) 1x, url(../img/sprite@1.5x.png) 1.5x, url(../img/sprite@3x.png) 3x);",
+		fmt.Sprintf("background-size: %dpx %dpx;", b.Dx(), b.Dy()),
+		"background-image: url(../img/sprite@1.5x.png);",
+		"background-image: url(../img/sprite@3x.png);",
+	} {
+		if !strings.Contains(sprite.Stylesheet, want) {
+			t.Errorf("stylesheet lacks %q:\n%s", want, sprite.Stylesheet)
+		}
+	}
+}
+
+func TestCreateSpriteGrid(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	// odd sizes and margins, which 1.5x cannot scale to whole pixels
+	files := writeImages(t, dir, 7)
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Densities = []float64{1.5, 3}
+		c.Margin = 3
+		c.LayoutFile = filepath.Join(dir, "sprite.json")
+	})
+
+	b := sprite.Image.Bounds()
+	if b.Dx()%2 != 0 || b.Dy()%2 != 0 {
+		t.Errorf("1x image is %v, off the grid of 2", b)
+	}
+
+	// so every density is an exact multiple of it
+	for _, s := range sprite.Scaled {
+		sb := s.Image.Bounds()
+		if float64(sb.Dx()) != float64(b.Dx())*s.Density || float64(sb.Dy()) != float64(b.Dy())*s.Density {
+			t.Errorf("%vx image is %v, not exactly %v times %v", s.Density, sb, s.Density, b)
+		}
+	}
+
+	for _, blk := range sprite.Layout.Blocks {
+		if blk.X%2 != 0 || blk.Y%2 != 0 || blk.Width%2 != 0 || blk.Height%2 != 0 {
+			t.Errorf("image %v is off the grid of 2", blk)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package packer
+
+import "fmt"
+
+// ErrorKind identifies the step of building a sprite that failed.
+type ErrorKind int
+
+const (
+	// ConfigError is an invalid configuration value.
+	ConfigError ErrorKind = iota
+	// ImageError is a source image that could not be opened or decoded.
+	ImageError
+	// TemplateError is a stylesheet or HTML template that could not be read, parsed or executed.
+	TemplateError
+	// OutputError is a sprite image or stylesheet that could not be encoded or written.
+	OutputError
+	// LayoutError is a set of blocks that could not be packed into a canvas.
+	LayoutError
+)
+
+// Error is the type of every error returned by CreateSprite, Save and TryFit.
+type Error struct {
+	Kind ErrorKind
+	// Path is the file being worked on, if any.
+	Path string
+	Msg  string
+	// Err is the underlying error, if any.
+	Err error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s", e.Msg, e.Err)
+	}
+
+	return e.Msg
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorf creates an Error of the given kind with a formatted message.
+func errorf(kind ErrorKind, path string, err error, format string, a ...interface{}) *Error {
+	return &Error{Kind: kind, Path: path, Msg: fmt.Sprintf(format, a...), Err: err}
+}
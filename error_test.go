@@ -0,0 +1,82 @@
+package packer
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 2)
+	notImage := filepath.Join(dir, "notes.png")
+	if err := ioutil.WriteFile(notImage, []byte("not a png"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(configure func(c *Config), files []string) error {
+		c := NewConfig()
+		configure(c)
+		_, err := c.CreateSprite(files)
+		return err
+	}
+
+	// nothing is printed, every failure is returned
+	stdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	for _, tc := range []struct {
+		name string
+		kind ErrorKind
+		err  error
+	}{
+		{"no images", ConfigError, create(func(c *Config) {}, nil)},
+		{"margin", ConfigError, create(func(c *Config) { c.Margin = -1 }, files)},
+		{"processor", ConfigError, create(func(c *Config) { c.Processor = "none" }, files)},
+		{"missing image", ImageError, create(func(c *Config) {}, []string{filepath.Join(dir, "missing.png")})},
+		{"not an image", ImageError, create(func(c *Config) {}, []string{notImage})},
+		{"template", TemplateError, create(func(c *Config) { c.Template = filepath.Join(dir, "missing.tmpl") }, files)},
+		{"save", OutputError, func() error {
+			c, sprite := createSprite(t, files, func(c *Config) { c.CSSPath = filepath.Join(dir, "missing") })
+			return c.Save(sprite)
+		}()},
+		{"layout", LayoutError, func() error {
+			_, err := TryFit(Blocks{NewBlock("a", -1, 1)}, FitOptions{})
+			return err
+		}()},
+	} {
+		var e *Error
+		if !errors.As(tc.err, &e) {
+			t.Errorf("%s returned %v, not an *Error", tc.name, tc.err)
+		} else if e.Kind != tc.kind {
+			t.Errorf("%s returned kind %d, not %d, %v", tc.name, e.Kind, tc.kind, e)
+		}
+	}
+
+	// the underlying error is kept
+	if err := create(func(c *Config) {}, []string{filepath.Join(dir, "missing.png")}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("missing image returned %v, not wrapping os.ErrNotExist", err)
+	}
+
+	w.Close()
+	os.Stdout = stdout
+
+	printed, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(printed) != 0 {
+		t.Errorf("printed %q", printed)
+	}
+}
@@ -9,47 +9,136 @@ import (
 	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
-// Given list of file paths, return map of css name to (path/extension removed) to image data
-func (c *Config) getImages(files []string) (map[string]*image.Image, error) {
-	images := make(map[string]*image.Image)
+// sourceFile is a decoded image file.
+type sourceFile struct {
+	fn  string
+	img image.Image
+}
+
+// sourceImage holds the files given for one image in the sprite, keyed by
+// their pixel density.  A file named icon@2x.png is the 2x version of icon.
+type sourceImage map[float64]*sourceFile
+
+// densityTagRE matches the pixel density tag at the end of a file name, e.g. "@2x"
+var densityTagRE = regexp.MustCompile(`@([0-9]+(\.[0-9]+)?)x$`)
+
+// Given list of file paths, return map of css name to (path/extension removed) to image data,
+// along with the names in the order their first file was given.
+func (c *Config) getImages(files []string) (map[string]sourceImage, []string, error) {
+	images := make(map[string]sourceImage)
+	var names []string
 	re := regexp.MustCompile("([^_a-zA-Z0-9])")
 
+	// tags only pair files when more than 1x is drawn, otherwise
+	// logo@2x.png is an image of its own
+	tagged := len(c.Densities) > 1
+
 	for _, fn := range files {
 		base := path.Base(fn)
 		ext := strings.ToLower(filepath.Ext(base))
-		name := re.ReplaceAllLiteralString(base[:len(base)-len(ext)], "_")
+		base = base[:len(base)-len(ext)]
+
+		// untagged files are resolved below, once all files are known
+		density := 0.0
+		if m := densityTagRE.FindStringSubmatch(base); m != nil && tagged {
+			density, _ = strconv.ParseFloat(m[1], 64)
+			base = base[:len(base)-len(m[0])]
+		}
+
+		name := re.ReplaceAllLiteralString(base, "_")
 
 		if ext != ".png" && ext != ".jpeg" && ext != ".jpg" {
-			return nil, fmt.Errorf("Unrecognized file extension, %q", ext)
+			return nil, nil, errorf(ImageError, fn, nil, "Unrecognized file extension, %q", ext)
+		}
+
+		if density < 0 || (density > 0 && density < 1) {
+			return nil, nil, errorf(ImageError, fn, nil, "Unsupported pixel density in file name, %q", fn)
 		}
 
 		f, err := os.Open(fn)
 		if err != nil {
-			return nil, fmt.Errorf("Could not open file, %q", fn)
+			return nil, nil, errorf(ImageError, fn, err, "Could not open file, %q", fn)
 		}
 
 		defer f.Close()
 
+		var img image.Image
 		if ext == ".png" {
-			img, err := png.Decode(f)
+			img, err = png.Decode(f)
 			if err != nil {
-				return nil, fmt.Errorf("Problem decoding PNG image, %q", fn)
+				return nil, nil, errorf(ImageError, fn, err, "Problem decoding PNG image, %q", fn)
 			}
 
-			images[name] = &img
-
 		} else if ext == ".jpg" || ext == ".jpeg" {
-			img, err := jpeg.Decode(f)
+			img, err = jpeg.Decode(f)
 			if err != nil {
-				return nil, fmt.Errorf("Problem decoding JPEG image, %q", fn)
+				return nil, nil, errorf(ImageError, fn, err, "Problem decoding JPEG image, %q", fn)
 			}
+		}
 
-			images[name] = &img
+		if images[name] == nil {
+			images[name] = make(sourceImage)
+			names = append(names, name)
 		}
+
+		images[name][density] = &sourceFile{fn: fn, img: img}
 	}
 
-	return images, nil
+	// An untagged file paired with tagged ones (icon.png with icon@2x.png)
+	// is the 1x version, otherwise it has the density of the source images.
+	for _, src := range images {
+		if f, ok := src[0]; ok {
+			delete(src, 0)
+			if len(src) > 0 {
+				src[1] = f
+			} else {
+				src[c.SourceDensity] = f
+			}
+		}
+	}
+
+	return images, names, nil
+}
+
+// master returns the file with the highest density, the one other densities
+// are scaled from.
+func (s sourceImage) master() (*sourceFile, float64) {
+	var best *sourceFile
+	density := 0.0
+	for d, f := range s {
+		if d > density {
+			best, density = f, d
+		}
+	}
+
+	return best, density
+}
+
+// mismatches describes every file whose size is not the size of the master
+// file scaled to its density, e.g. an icon.png that is not half of icon@2x.png.
+func (s sourceImage) mismatches() []string {
+	master, md := s.master()
+	mw, mh := master.img.Bounds().Dx(), master.img.Bounds().Dy()
+
+	var msgs []string
+	for d, f := range s {
+		if f == master {
+			continue
+		}
+
+		w, h := f.img.Bounds().Dx(), f.img.Bounds().Dy()
+		if float64(w)*md != float64(mw)*d || float64(h)*md != float64(mh)*d {
+			msgs = append(msgs, fmt.Sprintf("%s is %dx%d, expected %vx%v for %vx from %s (%dx%d)",
+				f.fn, w, h, float64(mw)*d/md, float64(mh)*d/md, d, master.fn, mw, mh))
+		}
+	}
+
+	sort.Strings(msgs)
+
+	return msgs
 }
@@ -0,0 +1,118 @@
+package packer
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeFilled writes a w x h png filled with c to dir, returning its path.
+func writeFilled(t *testing.T, dir, name string, w, h int, c color.Color) string {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), &image.Uniform{c}, image.ZP, draw.Src)
+
+	return writePNG(t, dir, name, img)
+}
+
+func TestGetImagesPairs(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	red := color.RGBA{255, 0, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+	files := []string{
+		writeFilled(t, dir, "icon.png", 10, 8, red),
+		writeFilled(t, dir, "icon@2x.png", 20, 16, blue),
+		writeFilled(t, dir, "logo.png", 12, 12, red),
+	}
+
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.Retina = true
+		c.LayoutFile = filepath.Join(dir, "sprite.json")
+	})
+
+	if n := strings.Count(sprite.Stylesheet, ".sprite_icon {"); n != 1 {
+		t.Errorf("icon.png and icon@2x.png made %d classes:\n%s", n, sprite.Stylesheet)
+	}
+
+	if len(sprite.Layout.Blocks) != 2 || len(sprite.Warnings) != 0 {
+		t.Fatalf("layout %v, warnings %v", sprite.Layout.Blocks, sprite.Warnings)
+	}
+
+	for _, b := range sprite.Layout.Blocks {
+		if b.Name != "icon" {
+			continue
+		}
+
+		if b.Width != 10 || b.Height != 8 {
+			t.Errorf("icon is %dx%d, not the 10x8 of icon.png", b.Width, b.Height)
+		}
+
+		// each density draws its own file rather than scaling another
+		if got := sprite.Image.At(b.X, b.Y); got != red {
+			t.Errorf("1x icon is %v, not the red of icon.png", got)
+		}
+
+		if got := sprite.RetinaImage.At(b.X*2+1, b.Y*2+1); got != blue {
+			t.Errorf("2x icon is %v, not the blue of icon@2x.png", got)
+		}
+	}
+}
+
+func TestGetImagesMismatch(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	black := color.RGBA{0, 0, 0, 255}
+	for _, tc := range []struct {
+		sizes     map[string][2]int
+		densities []float64
+		want      string
+	}{
+		// icon.png is not half of icon@2x.png
+		{map[string][2]int{"a.png": {10, 8}, "a@2x.png": {21, 16}}, []float64{2}, "a.png is 10x8, expected 10.5x8 for 1x from"},
+		// sizes are compared against the densest file
+		{map[string][2]int{"b.png": {10, 8}, "b@2x.png": {21, 16}, "b@3x.png": {30, 24}}, []float64{2, 3}, "b@2x.png is 21x16, expected 20x16 for 2x from"},
+	} {
+		var files []string
+		for name, size := range tc.sizes {
+			files = append(files, writeFilled(t, dir, name, size[0], size[1], black))
+		}
+
+		_, sprite := createSprite(t, files, func(c *Config) {
+			c.Densities = tc.densities
+		})
+
+		if len(sprite.Warnings) != 1 || !strings.Contains(sprite.Warnings[0], tc.want) {
+			t.Errorf("mismatched files warned %v, not %q", sprite.Warnings, tc.want)
+		}
+	}
+
+	// matching files do not warn
+	files := []string{writeFilled(t, dir, "c.png", 10, 8, black), writeFilled(t, dir, "c@2x.png", 20, 16, black)}
+	if _, sprite := createSprite(t, files, func(c *Config) { c.Retina = true }); len(sprite.Warnings) != 0 {
+		t.Errorf("matching files warned %v", sprite.Warnings)
+	}
+}
+
+func TestGetImagesPlainTags(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	// without more than 1x, a tagged file is an image of its own
+	files := []string{writeFilled(t, dir, "logo@2x.png", 13, 8, color.RGBA{0, 0, 0, 255})}
+	_, sprite := createSprite(t, files, func(c *Config) {
+		c.LayoutFile = filepath.Join(dir, "sprite.json")
+	})
+
+	if !strings.Contains(sprite.Stylesheet, ".sprite_logo_2x {") {
+		t.Errorf("logo@2x.png is not sprite_logo_2x:\n%s", sprite.Stylesheet)
+	}
+
+	if len(sprite.Layout.Blocks) != 1 || sprite.Layout.Blocks[0].Width != 13 || sprite.Layout.Blocks[0].Height != 8 {
+		t.Errorf("logo@2x.png was scaled to %v, not kept at 13x8", sprite.Layout.Blocks)
+	}
+}
@@ -1,7 +1,13 @@
 package packer
 
-// Layout is used to sort sprites in different ways to achieve different packs.
-//go:generate stringer -type=Layout
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Layout is used to sort sprites in different ways to achieve different
+// packs.  Besides the built-in layouts, more can be added with RegisterLayout.
 type Layout int
 
 const (
@@ -13,4 +19,140 @@ const (
 	LayoutByArea
 	// LayoutByMax sorts objects by max dimension, width or height
 	LayoutByMax
+	// LayoutByInput keeps objects in the order given
+	LayoutByInput
+	// LayoutByPerimeter sorts objects by perimeter
+	LayoutByPerimeter
+	// LayoutByDiagonal sorts objects by the length of their diagonal
+	LayoutByDiagonal
+	// LayoutByWidthAscending sorts objects by width, then height, narrowest first
+	LayoutByWidthAscending
+)
+
+// Sorter returns a sort.Interface ordering blocks for packing, or nil to
+// pack them in the order given.
+type Sorter func(blocks Blocks) sort.Interface
+
+// layoutEntry is a registered sort strategy.
+type layoutEntry struct {
+	name   string
+	sorter Sorter
+}
+
+var (
+	layoutsMu sync.RWMutex
+	// layouts is indexed by Layout
+	layouts = []layoutEntry{
+		{"width", func(b Blocks) sort.Interface { return BlocksByWidth(b) }},
+		{"height", func(b Blocks) sort.Interface { return BlocksByHeight(b) }},
+		{"area", func(b Blocks) sort.Interface { return BlocksByArea(b) }},
+		{"max", func(b Blocks) sort.Interface { return BlocksByMax(b) }},
+		{"input", func(b Blocks) sort.Interface { return nil }},
+		{"perimeter", func(b Blocks) sort.Interface { return BlocksByPerimeter(b) }},
+		{"diagonal", func(b Blocks) sort.Interface { return BlocksByDiagonal(b) }},
+		{"width-ascending", func(b Blocks) sort.Interface { return BlocksByWidthAscending(b) }},
+	}
 )
+
+// RegisterLayout makes a sort strategy available by name, for Fit to try
+// along with the others, and returns its Layout.  Registering a name again
+// replaces its sorter and returns the same Layout.  It panics if sorter is
+// nil, a Sorter returns nil to keep the order given.
+func RegisterLayout(name string, sorter Sorter) Layout {
+	if sorter == nil {
+		panic(fmt.Sprintf("packer: RegisterLayout %q with a nil sorter", name))
+	}
+
+	layoutsMu.Lock()
+	defer layoutsMu.Unlock()
+
+	for i, l := range layouts {
+		if l.name == name {
+			layouts[i].sorter = sorter
+			return Layout(i)
+		}
+	}
+
+	layouts = append(layouts, layoutEntry{name, sorter})
+
+	return Layout(len(layouts) - 1)
+}
+
+// Layouts returns every registered layout, the sort orders Fit tries.
+func Layouts() []Layout {
+	layoutsMu.RLock()
+	defer layoutsMu.RUnlock()
+
+	all := make([]Layout, len(layouts))
+	for i := range layouts {
+		all[i] = Layout(i)
+	}
+
+	return all
+}
+
+// ParseLayout returns the layout registered with name, e.g. "height".
+func ParseLayout(name string) (Layout, error) {
+	layoutsMu.RLock()
+	defer layoutsMu.RUnlock()
+
+	for i, l := range layouts {
+		if l.name == name {
+			return Layout(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown sort order %q", name)
+}
+
+// LayoutNames returns the names of all registered layouts, sorted.
+func LayoutNames() []string {
+	layoutsMu.RLock()
+	defer layoutsMu.RUnlock()
+
+	names := make([]string, 0, len(layouts))
+	for _, l := range layouts {
+		names = append(names, l.name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// String returns the name the layout was registered with.
+func (l Layout) String() string {
+	layoutsMu.RLock()
+	defer layoutsMu.RUnlock()
+
+	if l < 0 || int(l) >= len(layouts) {
+		return fmt.Sprintf("Layout(%d)", l)
+	}
+
+	return layouts[l].name
+}
+
+// valid reports whether l is a registered layout.
+func (l Layout) valid() bool {
+	layoutsMu.RLock()
+	defer layoutsMu.RUnlock()
+
+	return l >= 0 && int(l) < len(layouts)
+}
+
+// sort orders blocks for packing with layout l, leaving them in the order
+// given when l is not registered.
+func (l Layout) sort(blocks Blocks) {
+	if !l.valid() {
+		return
+	}
+
+	layoutsMu.RLock()
+	sorter := layouts[l].sorter
+	layoutsMu.RUnlock()
+
+	// a stable sort keeps any ties left by the sorter in the order given
+	if s := sorter(blocks); s != nil {
+		sort.Stable(s)
+	}
+}
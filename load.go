@@ -0,0 +1,418 @@
+package packer
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// configFile mirrors the keys accepted in a packer.toml configuration file.
+// Each table under [sprites] describes another sprite, inheriting any keys
+// it does not define from the top level of the file.
+type configFile struct {
+	Includes   []string              `toml:"includes"`
+	Retina     bool                  `toml:"retina"`
+	Densities  []string              `toml:"densities"`
+	Source     string                `toml:"sourcedensity"`
+	Base64     bool                  `toml:"base64"`
+	HTML       bool                  `toml:"html"`
+	HTMLDir    string                `toml:"htmldir"`
+	Name       string                `toml:"name"`
+	Format     string                `toml:"format"`
+	Processor  string                `toml:"processor"`
+	Algorithm  string                `toml:"algorithm"`
+	BinWidth   int                   `toml:"binwidth"`
+	Orient     string                `toml:"orientation"`
+	Sort       string                `toml:"sort"`
+	NoSort     bool                  `toml:"nosort"`
+	MaxWidth   int                   `toml:"maxwidth"`
+	MaxHeight  int                   `toml:"maxheight"`
+	PowerOfTwo bool                  `toml:"poweroftwo"`
+	Multiple   int                   `toml:"multipleof"`
+	Score      string                `toml:"score"`
+	Optimize   string                `toml:"optimize"`
+	Iterations int                   `toml:"iterations"`
+	LayoutFile string                `toml:"layoutfile"`
+	Repack     float64               `toml:"repackwaste"`
+	Template   string                `toml:"template"`
+	CSSDir     string                `toml:"cssdir"`
+	ImgDir     string                `toml:"imgdir"`
+	Stylesheet string                `toml:"stylesheet"`
+	Sprite     string                `toml:"sprite"`
+	CSSPath    string                `toml:"csspath"`
+	Hover      string                `toml:"hover"`
+	Background string                `toml:"background"`
+	Prefix     string                `toml:"prefix"`
+	Margin     int                   `toml:"margin"`
+	Spacing    int                   `toml:"spacing"`
+	Border     int                   `toml:"border"`
+	Extrude    int                   `toml:"extrude"`
+	Trim       bool                  `toml:"trim"`
+	Threshold  int                   `toml:"trimthreshold"`
+	Sprites    map[string]configFile `toml:"sprites"`
+}
+
+// NewConfig returns a configuration populated with the same default values
+// used by the command line tool.
+func NewConfig() *Config {
+	return &Config{
+		CSSPath:    "css/",
+		ImgPath:    "img/",
+		ImgURL:     "../img",
+		Format:     "png",
+		Processor:  "css",
+		Name:       "sprite",
+		Prefix:     "sprite",
+		Hover:      hoverTrigger,
+		Margin:     4,
+		Background: "transparent",
+	}
+}
+
+// LoadConfig reads a TOML configuration file.  All paths in the file are
+// relative to the location of the file itself.  Keys missing from the file
+// keep the values from NewConfig.  Any [sprites] tables are ignored, use
+// LoadConfigs to read those.
+func LoadConfig(fn string) (*Config, error) {
+	f, md, dir, err := decodeConfig(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewConfig()
+	if err := c.apply(f, md, dir); err != nil {
+		return nil, fmt.Errorf("Problem in config file, %q: %s", fn, err)
+	}
+
+	return c, nil
+}
+
+// LoadConfigs reads a TOML configuration file describing one or more
+// sprites.  Every table under [sprites] becomes a Config named after the
+// table, ordered by name.  A file without any [sprites] tables returns the
+// single Config from LoadConfig.
+func LoadConfigs(fn string) ([]*Config, error) {
+	f, md, dir, err := decodeConfig(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(f.Sprites) == 0 {
+		c := NewConfig()
+		if err := c.apply(f, md, dir); err != nil {
+			return nil, fmt.Errorf("Problem in config file, %q: %s", fn, err)
+		}
+
+		return []*Config{c}, nil
+	}
+
+	names := make([]string, 0, len(f.Sprites))
+	for name := range f.Sprites {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	configs := make([]*Config, len(names))
+	for i, name := range names {
+		sf := f.Sprites[name]
+		c := NewConfig()
+		if err := c.apply(f, md, dir); err != nil {
+			return nil, fmt.Errorf("Problem in config file, %q: %s", fn, err)
+		}
+
+		c.Name = name
+		if err := c.apply(&sf, md, dir, "sprites", name); err != nil {
+			return nil, fmt.Errorf("Problem in config file, %q, sprite %q: %s", fn, name, err)
+		}
+
+		configs[i] = c
+	}
+
+	// The sprites are built at once, so no two may write the same files.
+	stylesheets := make(map[string]string)
+	images := make(map[string]string)
+	layouts := make(map[string]string)
+	for i, c := range configs {
+		for _, out := range []struct {
+			written map[string]string
+			path    string
+		}{
+			{stylesheets, filepath.Join(c.CSSPath, c.Name)},
+			{images, filepath.Join(c.ImgPath, c.Name)},
+			{layouts, c.LayoutFile},
+		} {
+			if out.path == "" {
+				continue
+			}
+
+			if other, ok := out.written[out.path]; ok {
+				return nil, fmt.Errorf("Problem in config file, %q: sprites %q and %q both write to %q", fn, other, names[i], out.path)
+			}
+
+			out.written[out.path] = names[i]
+		}
+	}
+
+	return configs, nil
+}
+
+// decodeConfig parses the TOML file fn and returns its absolute directory.
+func decodeConfig(fn string) (*configFile, toml.MetaData, string, error) {
+	var f configFile
+	md, err := toml.DecodeFile(fn, &f)
+	if err != nil {
+		return nil, md, "", fmt.Errorf("Problem reading config file, %q: %s", fn, err)
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(fn))
+	if err != nil {
+		return nil, md, "", err
+	}
+
+	return &f, md, dir, nil
+}
+
+// apply copies the keys defined in f over c, resolving paths against dir.
+// The keys locate f within the file, for example "sprites", "icons".
+func (c *Config) apply(f *configFile, md toml.MetaData, dir string, keys ...string) error {
+	defined := func(key string) bool {
+		return md.IsDefined(append(append([]string{}, keys...), key)...)
+	}
+
+	if defined("retina") {
+		c.Retina = f.Retina
+	}
+
+	if defined("densities") {
+		c.Densities = make([]float64, len(f.Densities))
+		for i, s := range f.Densities {
+			d, err := ParseDensity(s)
+			if err != nil {
+				return err
+			}
+
+			c.Densities[i] = d
+		}
+	}
+
+	if f.Source != "" {
+		d, err := ParseDensity(f.Source)
+		if err != nil {
+			return err
+		}
+
+		c.SourceDensity = d
+	}
+
+	if defined("base64") {
+		c.Base64 = f.Base64
+	}
+
+	if defined("html") {
+		c.HTML = f.HTML
+	}
+
+	if defined("hover") {
+		c.Hover = f.Hover
+	}
+
+	if defined("background") {
+		c.Background = f.Background
+	}
+
+	if defined("prefix") {
+		c.Prefix = f.Prefix
+	}
+
+	if defined("margin") {
+		c.Margin = f.Margin
+	}
+
+	if defined("spacing") {
+		c.Spacing = f.Spacing
+	}
+
+	if defined("border") {
+		c.Border = f.Border
+	}
+
+	if defined("extrude") {
+		c.Extrude = f.Extrude
+	}
+
+	if defined("trim") {
+		c.Trim = f.Trim
+	}
+
+	if defined("trimthreshold") {
+		c.TrimThreshold = f.Threshold
+	}
+
+	if f.Name != "" {
+		c.Name = f.Name
+	}
+
+	if f.Format != "" {
+		c.Format = f.Format
+	}
+
+	if f.Processor != "" {
+		c.Processor = f.Processor
+	}
+
+	if f.Algorithm != "" {
+		c.Algorithm = f.Algorithm
+	}
+
+	if defined("binwidth") {
+		c.BinWidth = f.BinWidth
+	}
+
+	if f.Orient != "" {
+		c.Orientation = f.Orient
+	}
+
+	if f.Sort != "" {
+		c.Sort = f.Sort
+	}
+
+	if defined("nosort") {
+		c.NoSort = f.NoSort
+	}
+
+	if defined("maxwidth") {
+		c.MaxWidth = f.MaxWidth
+	}
+
+	if defined("maxheight") {
+		c.MaxHeight = f.MaxHeight
+	}
+
+	if defined("poweroftwo") {
+		c.PowerOfTwo = f.PowerOfTwo
+	}
+
+	if defined("multipleof") {
+		c.SizeMultiple = f.Multiple
+	}
+
+	if f.Score != "" {
+		c.Score = f.Score
+	}
+
+	if f.Optimize != "" {
+		d, err := time.ParseDuration(f.Optimize)
+		if err != nil {
+			return fmt.Errorf("illegal optimize duration %q", f.Optimize)
+		}
+
+		c.Optimize = d
+	}
+
+	if defined("iterations") {
+		c.Iterations = f.Iterations
+	}
+
+	if f.LayoutFile != "" {
+		c.LayoutFile = resolvePath(dir, f.LayoutFile)
+	}
+
+	if defined("repackwaste") {
+		c.RepackWaste = f.Repack
+	}
+
+	if f.Template != "" {
+		c.Template = resolvePath(dir, f.Template)
+	}
+
+	if f.HTMLDir != "" {
+		c.HTMLPath = f.HTMLDir
+	}
+
+	if f.CSSDir != "" {
+		c.CSSPath = f.CSSDir
+	}
+
+	if f.ImgDir != "" {
+		c.ImgPath = f.ImgDir
+	}
+
+	// The sprite name comes from the image file name, or from the
+	// stylesheet when no image file is given.
+	if f.Stylesheet != "" {
+		c.CSSPath = path.Dir(f.Stylesheet)
+		c.Name = baseName(f.Stylesheet)
+	}
+
+	if f.Sprite != "" {
+		name := baseName(f.Sprite)
+		if f.Stylesheet != "" && name != c.Name {
+			return fmt.Errorf("stylesheet %q and sprite %q must share the same base name", f.Stylesheet, f.Sprite)
+		}
+
+		c.ImgPath = path.Dir(f.Sprite)
+		c.Name = name
+		if ext := strings.ToLower(path.Ext(f.Sprite)); ext == ".jpg" || ext == ".jpeg" {
+			c.Format = "jpg"
+		} else if ext == ".png" {
+			c.Format = "png"
+		}
+	}
+
+	// csspath is the URL of the sprite image as seen from the stylesheet
+	if f.CSSPath != "" {
+		c.ImgURL = f.CSSPath
+		if path.Ext(f.CSSPath) != "" {
+			c.ImgURL = path.Dir(f.CSSPath)
+		}
+	}
+
+	if defined("includes") {
+		c.Includes = make([]string, len(f.Includes))
+		for i, inc := range f.Includes {
+			c.Includes[i] = resolvePath(dir, inc)
+		}
+	}
+
+	c.CSSPath = resolvePath(dir, c.CSSPath)
+	c.ImgPath = resolvePath(dir, c.ImgPath)
+	c.HTMLPath = resolvePath(dir, c.HTMLPath)
+
+	return nil
+}
+
+// Files expands the Includes glob patterns into a list of image files.
+func (c *Config) Files() ([]string, error) {
+	var files []string
+	for _, pattern := range c.Includes {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Bad include pattern, %q", pattern)
+		}
+
+		files = append(files, matches...)
+	}
+
+	return files, nil
+}
+
+// resolvePath makes p relative to dir unless p is already absolute.
+func resolvePath(dir, p string) string {
+	if p == "" || filepath.IsAbs(p) {
+		return p
+	}
+
+	return filepath.Join(dir, p)
+}
+
+// baseName returns the file name of p without directory or extension.
+func baseName(p string) string {
+	base := path.Base(p)
+	return base[:len(base)-len(path.Ext(base))]
+}
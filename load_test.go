@@ -0,0 +1,192 @@
+package packer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeConfig writes a packer.toml holding src to dir and returns its path.
+func writeConfig(t *testing.T, dir, src string) string {
+	fn := filepath.Join(dir, "packer.toml")
+	if err := ioutil.WriteFile(fn, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return fn
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	for _, tc := range []struct {
+		src  string
+		got  func(c *Config) interface{}
+		want interface{}
+	}{
+		// keys missing from the file keep their defaults
+		{``, func(c *Config) interface{} { return c.Margin }, 4},
+		{``, func(c *Config) interface{} { return c.Hover }, "_hover"},
+		{`margin=0`, func(c *Config) interface{} { return c.Margin }, 0},
+		{`retina=true`, func(c *Config) interface{} { return c.Retina }, true},
+		{`prefix="icon"`, func(c *Config) interface{} { return c.Prefix }, "icon"},
+		{`background="#fff"`, func(c *Config) interface{} { return c.Background }, "#fff"},
+		{`densities=["1.5x", "3"]`, func(c *Config) interface{} { return c.Densities }, []float64{1.5, 3}},
+		{`optimize="2s"`, func(c *Config) interface{} { return c.Optimize.String() }, "2s"},
+		{`iterations=500`, func(c *Config) interface{} { return c.Iterations }, 500},
+		// paths are relative to the config file
+		{`includes=["./icons/*.png", "/abs/*.png"]`, func(c *Config) interface{} { return c.Includes }, []string{filepath.Join(dir, "icons/*.png"), "/abs/*.png"}},
+		{`cssdir="./out/css"`, func(c *Config) interface{} { return c.CSSPath }, filepath.Join(dir, "out/css")},
+		{`imgdir="img"`, func(c *Config) interface{} { return c.ImgPath }, filepath.Join(dir, "img")},
+		{`template="t.tmpl"`, func(c *Config) interface{} { return c.Template }, filepath.Join(dir, "t.tmpl")},
+		{`stylesheet="./out/icons.css"`, func(c *Config) interface{} { return []string{c.CSSPath, c.Name} }, []string{filepath.Join(dir, "out"), "icons"}},
+		{`sprite="./img/icons.jpg"`, func(c *Config) interface{} { return []string{c.ImgPath, c.Name, c.Format} }, []string{filepath.Join(dir, "img"), "icons", "jpg"}},
+		// csspath is the url of the image, so only its directory is kept
+		{`csspath="/img/sprites.png"`, func(c *Config) interface{} { return c.ImgURL }, "/img"},
+		{`csspath="../img"`, func(c *Config) interface{} { return c.ImgURL }, "../img"},
+	} {
+		c, err := LoadConfig(writeConfig(t, dir, tc.src))
+		if err != nil {
+			t.Errorf("%q failed, %v", tc.src, err)
+			continue
+		}
+
+		if got := tc.got(c); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q gave %v, not %v", tc.src, got, tc.want)
+		}
+	}
+
+	for _, src := range []string{
+		`margin=`,
+		`densities=["fast"]`,
+		`optimize="soon"`,
+		`stylesheet="a.css"` + "\n" + `sprite="b.png"`,
+	} {
+		if _, err := LoadConfig(writeConfig(t, dir, src)); err == nil {
+			t.Errorf("%q was accepted", src)
+		}
+	}
+}
+
+func TestConfigFiles(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	if err := os.Mkdir(filepath.Join(dir, "icons"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, fn := range []string{"icons/b.png", "icons/a.png", "icons/notes.txt", "logo.png"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, fn), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c, err := LoadConfig(writeConfig(t, dir, `includes=["./icons/*.png", "logo.png", "missing/*.png"]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := c.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{filepath.Join(dir, "icons/a.png"), filepath.Join(dir, "icons/b.png"), filepath.Join(dir, "logo.png")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("Files returned %v, not %v", files, want)
+	}
+
+	c.Includes = []string{"[bad"}
+	if _, err = c.Files(); err == nil {
+		t.Error("Files accepted a bad pattern")
+	}
+}
+
+func TestLoadConfigs(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	configs, err := LoadConfigs(writeConfig(t, dir, `
+cssdir="./css"
+margin=2
+prefix="icon"
+includes=["./common/*.png"]
+
+[sprites.flags]
+includes=["./flags/*.png"]
+margin=0
+format="jpg"
+
+[sprites.arrows]
+imgdir="./arrows/img"
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(configs) != 2 || configs[0].Name != "arrows" || configs[1].Name != "flags" {
+		t.Fatalf("LoadConfigs returned %v, not arrows and flags", configs)
+	}
+
+	arrows, flags := configs[0], configs[1]
+
+	// top level keys are inherited unless a sprite sets its own
+	if arrows.Margin != 2 || flags.Margin != 0 || arrows.Prefix != "icon" || flags.Prefix != "icon" {
+		t.Errorf("margins %d, %d and prefixes %q, %q were not inherited", arrows.Margin, flags.Margin, arrows.Prefix, flags.Prefix)
+	}
+
+	if arrows.Format != "png" || flags.Format != "jpg" {
+		t.Errorf("formats %q and %q were not overridden", arrows.Format, flags.Format)
+	}
+
+	// every path is relative to the config file
+	for _, tc := range []struct {
+		got, want interface{}
+	}{
+		{arrows.Includes, []string{filepath.Join(dir, "common/*.png")}},
+		{flags.Includes, []string{filepath.Join(dir, "flags/*.png")}},
+		{arrows.CSSPath, filepath.Join(dir, "css")},
+		{flags.CSSPath, filepath.Join(dir, "css")},
+		{arrows.ImgPath, filepath.Join(dir, "arrows/img")},
+		{flags.ImgPath, filepath.Join(dir, "img")},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("path %v is not %v", tc.got, tc.want)
+		}
+	}
+
+	// without any [sprites] tables the file describes one sprite
+	configs, err = LoadConfigs(writeConfig(t, dir, `name="single"`))
+	if err != nil || len(configs) != 1 || configs[0].Name != "single" {
+		t.Errorf("LoadConfigs of a single sprite returned %v, %v", configs, err)
+	}
+}
+
+func TestLoadConfigsDuplicateOutput(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	for _, src := range []string{
+		"[sprites.a]\nname=\"icons\"\n[sprites.b]\nname=\"icons\"\n",
+		"[sprites.a]\nstylesheet=\"css/x.css\"\n[sprites.b]\nsprite=\"css/x.png\"\ncssdir=\"css\"\n",
+	} {
+		if _, err := LoadConfigs(writeConfig(t, dir, src)); err == nil {
+			t.Errorf("sprites writing the same files were accepted, %q", src)
+		}
+	}
+
+	src := "layoutfile=\"sprite.json\"\n[sprites.a]\n[sprites.b]\n"
+	if _, err := LoadConfigs(writeConfig(t, dir, src)); err == nil {
+		t.Error("sprites sharing a layout file were accepted")
+	}
+
+	// the same name in different directories is fine
+	src = "[sprites.a]\nname=\"icons\"\ncssdir=\"a\"\nimgdir=\"a\"\n[sprites.b]\nname=\"icons\"\ncssdir=\"b\"\nimgdir=\"b\"\n"
+	if _, err := LoadConfigs(writeConfig(t, dir, src)); err != nil {
+		t.Errorf("sprites writing different files were refused, %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+package packer
+
+import "math"
+
+// MaxRects heuristics choose which free rectangle a block is placed in.
+const (
+	// bestShortSideFit leaves the smallest leftover on the shorter side
+	bestShortSideFit = iota
+	// bestAreaFit leaves the smallest leftover area
+	bestAreaFit
+	// bottomLeft places the block as high, then as far left, as possible
+	bottomLeft
+)
+
+// maxRects tracks the maximal free rectangles of a fixed size bin, as
+// described in Jukka Jylänki's "A Thousand Ways to Pack the Bin".
+type maxRects struct {
+	width     int
+	height    int
+	heuristic int
+	free      Blocks
+}
+
+// fitMaxRects packs the blocks, in order, with the MaxRects algorithm into
+// a bin of the given width, doubling its height until everything fits.
+func fitMaxRects(blocks Blocks, width, heuristic int) *Canvas {
+	area, maxH := 0, 0
+	for _, b := range blocks {
+		area += b.Width * b.Height
+		maxH = max(maxH, b.Height)
+	}
+
+	width = binWidth(blocks, width)
+	h := max(maxH, int(math.Ceil(float64(area)/float64(max(width, 1))*1.2)))
+
+	canvas := packMaxRects(blocks, width, h, heuristic)
+	for canvas == nil {
+		h *= 2
+		canvas = packMaxRects(blocks, width, h, heuristic)
+	}
+
+	return canvas
+}
+
+// packMaxRects packs the blocks into a width x height bin, returning nil if
+// they do not all fit.  The canvas is trimmed to the blocks it contains.
+func packMaxRects(blocks Blocks, width, height, heuristic int) *Canvas {
+	m := &maxRects{
+		width:     width,
+		height:    height,
+		heuristic: heuristic,
+		free:      Blocks{NewBlock("", width, height)},
+	}
+
+	root := NewBlock("#root#", 0, 0)
+	placed := make(Blocks, len(blocks))
+	for i, b := range blocks {
+		node := m.findNode(b.Width, b.Height)
+		if node == nil {
+			return nil
+		}
+
+		node.Name = b.Name
+		m.place(node)
+		placed[i] = node
+
+		root.Width = max(root.Width, node.X+node.Width)
+		root.Height = max(root.Height, node.Y+node.Height)
+	}
+
+	return &Canvas{Root: root, Blocks: placed}
+}
+
+// findNode returns the position for a w x h block chosen by the heuristic.
+func (m *maxRects) findNode(w, h int) *Block {
+	var best *Block
+	bestScore1, bestScore2 := math.MaxInt32, math.MaxInt32
+
+	for _, r := range m.free {
+		if w > r.Width || h > r.Height {
+			continue
+		}
+
+		var score1, score2 int
+		leftoverW := r.Width - w
+		leftoverH := r.Height - h
+
+		switch m.heuristic {
+		case bestAreaFit:
+			score1 = r.Width*r.Height - w*h
+			score2 = min(leftoverW, leftoverH)
+		case bottomLeft:
+			score1 = r.Y + h
+			score2 = r.X
+		default:
+			score1 = min(leftoverW, leftoverH)
+			score2 = max(leftoverW, leftoverH)
+		}
+
+		if score1 < bestScore1 || (score1 == bestScore1 && score2 < bestScore2) {
+			best = &Block{X: r.X, Y: r.Y, Width: w, Height: h}
+			bestScore1, bestScore2 = score1, score2
+		}
+	}
+
+	return best
+}
+
+// place removes the area used by node from the free rectangles.
+func (m *maxRects) place(node *Block) {
+	var kept, split Blocks
+	for _, r := range m.free {
+		if !intersects(r, node) {
+			kept = append(kept, r)
+			continue
+		}
+
+		// keep the parts of r on each side of node
+		if node.X > r.X {
+			split = append(split, &Block{X: r.X, Y: r.Y, Width: node.X - r.X, Height: r.Height})
+		}
+
+		if node.X+node.Width < r.X+r.Width {
+			split = append(split, &Block{X: node.X + node.Width, Y: r.Y, Width: r.X + r.Width - node.X - node.Width, Height: r.Height})
+		}
+
+		if node.Y > r.Y {
+			split = append(split, &Block{X: r.X, Y: r.Y, Width: r.Width, Height: node.Y - r.Y})
+		}
+
+		if node.Y+node.Height < r.Y+r.Height {
+			split = append(split, &Block{X: r.X, Y: node.Y + node.Height, Width: r.Width, Height: r.Y + r.Height - node.Y - node.Height})
+		}
+	}
+
+	// Only the new rectangles can be contained by another one, since the
+	// kept ones were maximal already.
+	for i, r := range split {
+		if r == nil {
+			continue
+		}
+
+		for j, o := range split {
+			// of two identical rectangles, keep the first
+			if i != j && o != nil && contains(o, r) && (j < i || !contains(r, o)) {
+				split[i] = nil
+				break
+			}
+		}
+
+		if split[i] == nil {
+			continue
+		}
+
+		for _, o := range kept {
+			if contains(o, r) {
+				split[i] = nil
+				break
+			}
+		}
+	}
+
+	for _, r := range split {
+		if r != nil {
+			kept = append(kept, r)
+		}
+	}
+
+	m.free = kept
+}
+
+// intersects reports whether a and b overlap.
+func intersects(a, b *Block) bool {
+	return a.X < b.X+b.Width && b.X < a.X+a.Width &&
+		a.Y < b.Y+b.Height && b.Y < a.Y+a.Height
+}
+
+// contains reports whether a contains all of b.
+func contains(a, b *Block) bool {
+	return b.X >= a.X && b.Y >= a.Y &&
+		b.X+b.Width <= a.X+a.Width && b.Y+b.Height <= a.Y+a.Height
+}
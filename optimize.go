@@ -0,0 +1,155 @@
+package packer
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// OptimizeOptions controls FitOptimized.
+type OptimizeOptions struct {
+	FitOptions
+	// Duration limits the search.  The search also stops when Context is
+	// done, or after Iterations.  With none of them, FitOptimized returns
+	// what Fit would.
+	Duration time.Duration
+	// Iterations, when above 0, limits the search to that many changes.
+	// Limited only by Iterations, a search with the same Seed always finds
+	// the same canvas, however fast the machine.
+	Iterations int
+	// Context stops the search when it is done, if not nil.
+	Context context.Context
+	// Seed seeds the random search, so a search of the same length finds
+	// the same canvas.
+	Seed int64
+	// Rotate lets the search turn blocks by 90 degrees, marking them
+	// Rotated.  CSS cannot show a rotated image, so sprites never do.
+	Rotate bool
+}
+
+// candidate is an order, and orientation, to pack blocks in.
+type candidate struct {
+	blocks  Blocks
+	rotated []bool
+}
+
+// FitOptimized packs blocks like FitWith, then keeps searching for a better
+// canvas, as judged by the scorer, until the time or iterations are up.  The
+// search starts from the order the best Fit candidate packed its blocks in,
+// and repeatedly packs small random changes to the best order found,
+// swapping, moving or turning blocks, with the algorithm that packed the Fit
+// candidate.  With opts.Width set, blocks are never turned wider than it.
+// It always returns the best canvas found so far.
+func FitOptimized(blocks Blocks, opts OptimizeOptions) *Canvas {
+	fo := opts.FitOptions.defaults()
+	best := fitAll(blocks, fo)
+
+	ctx := opts.Context
+	if ctx == nil {
+		if opts.Duration <= 0 && opts.Iterations <= 0 {
+			return best
+		}
+
+		ctx = context.Background()
+	}
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	if len(blocks) == 0 || (len(blocks) < 2 && !opts.Rotate) {
+		return best
+	}
+
+	// canvas blocks are in the order they were packed
+	current := candidate{blocks: make(Blocks, len(best.Blocks)), rotated: make([]bool, len(best.Blocks))}
+	for i, b := range best.Blocks {
+		current.blocks[i] = NewBlock(b.Name, b.Width, b.Height)
+	}
+
+	rnd := rand.New(rand.NewSource(opts.Seed))
+	algorithm := best.Algorithm
+
+	for i := 0; opts.Iterations <= 0 || i < opts.Iterations; i++ {
+		select {
+		case <-ctx.Done():
+			return best
+		default:
+		}
+
+		next := current.mutate(rnd, opts.Rotate, fo.Width)
+		canvas := next.pack(algorithm, fo)
+
+		// equal scores are accepted too, to wander across plateaus
+		if canvas.Score <= best.Score {
+			best = canvas
+			current = next
+		}
+	}
+
+	return best
+}
+
+// mutate returns a copy of c with a small random change.  Blocks are only
+// turned when that leaves them no wider than width, if it is set.
+func (c candidate) mutate(rnd *rand.Rand, rotate bool, width int) candidate {
+	n := len(c.blocks)
+	next := candidate{blocks: append(Blocks{}, c.blocks...), rotated: append([]bool{}, c.rotated...)}
+
+	op := rnd.Intn(10)
+	if n < 2 {
+		op = 9
+	}
+
+	switch {
+	case op < 5:
+		// swap two blocks
+		i, j := rnd.Intn(n), rnd.Intn(n)
+		next.blocks[i], next.blocks[j] = next.blocks[j], next.blocks[i]
+		next.rotated[i], next.rotated[j] = next.rotated[j], next.rotated[i]
+	case op < 8 || !rotate:
+		// move a block elsewhere
+		i, j := rnd.Intn(n), rnd.Intn(n)
+		b, r := next.blocks[i], next.rotated[i]
+		next.blocks = append(next.blocks[:i], next.blocks[i+1:]...)
+		next.rotated = append(next.rotated[:i], next.rotated[i+1:]...)
+		next.blocks = append(next.blocks[:j], append(Blocks{b}, next.blocks[j:]...)...)
+		next.rotated = append(next.rotated[:j], append([]bool{r}, next.rotated[j:]...)...)
+	default:
+		// turn a block, unless it would no longer fit the width
+		i := rnd.Intn(n)
+		if next.rotated[i] || width == 0 || next.blocks[i].Height <= width {
+			next.rotated[i] = !next.rotated[i]
+		}
+	}
+
+	return next
+}
+
+// pack packs the blocks of c, in order, with algorithm.
+func (c candidate) pack(algorithm Algorithm, opts FitOptions) *Canvas {
+	blocks := make(Blocks, len(c.blocks))
+	for i, b := range c.blocks {
+		if c.rotated[i] {
+			blocks[i] = NewBlock(b.Name, b.Height, b.Width)
+		} else {
+			blocks[i] = NewBlock(b.Name, b.Width, b.Height)
+		}
+	}
+
+	canvas := algorithm.pack(blocks, opts.Width)
+	canvas.Algorithm = algorithm
+	canvas.Layout = LayoutByInput
+
+	// every algorithm returns the blocks in the order they were given
+	for i, b := range canvas.Blocks {
+		b.Rotated = c.rotated[i]
+	}
+
+	opts.Sizing.padCanvas(canvas)
+	canvas.Score = opts.Scorer.Score(canvas)
+
+	return canvas
+}
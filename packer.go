@@ -1,86 +1,248 @@
 package packer
 
-import (
-	"sort"
-)
+import "sort"
+
+// FitOptions controls how Fit packs blocks.  The zero value packs the
+// blocks like Fit.
+type FitOptions struct {
+	// Algorithms are the packing algorithms to try, all of Algorithms when empty.
+	Algorithms []Algorithm
+	// Layouts are the sort orders to try, every registered layout when empty.
+	// Layouts not registered are skipped.
+	Layouts []Layout
+	// Width is the fixed width of a canvas that only grows downward, or 0.
+	// Only algorithms that can pack into a fixed width are then tried.
+	Width int
+	// Sizing constrains the dimensions of the canvas.
+	Sizing Sizing
+	// Scorer rates the candidates, the one with the lowest score is kept.
+	// WasteScorer when nil.
+	Scorer Scorer
+}
 
-// Fit packs blocks into a rectangle using 4 different sorting algorithms,
-// modifying the x/y of the block to give the tighest pack in a rectangle.
+// Fit packs blocks into a rectangle using every packing algorithm with
+// every registered layout, modifying the x/y of the block to give the
+// tighest pack in a rectangle.
 func Fit(blocks Blocks) *Canvas {
+	return FitWith(blocks, FitOptions{})
+}
+
+// FitWith packs blocks like Fit, as controlled by opts.
+func FitWith(blocks Blocks, opts FitOptions) *Canvas {
+	return fitAll(blocks, opts.defaults())
+}
+
+// TryFit packs blocks like FitWith, returning an error rather than a canvas
+// for blocks of negative size, or too large for an image to hold.  No
+// blocks give an empty canvas.  Any error returned is an *Error.
+func TryFit(blocks Blocks, opts FitOptions) (*Canvas, error) {
+	var area int64
+	for _, b := range blocks {
+		if b.Width < 0 || b.Height < 0 {
+			return nil, errorf(LayoutError, "", nil, "block %q has a negative size, %dx%d", b.Name, b.Width, b.Height)
+		}
+
+		if err := checkSize(b.Width, b.Height); err != nil {
+			return nil, err
+		}
+
+		area += int64(b.Width) * int64(b.Height)
+		if area > maxPixels {
+			return nil, errorf(LayoutError, "", nil, "blocks cover more than the %d pixels an image can hold", maxPixels)
+		}
+	}
+
+	canvas := FitWith(blocks, opts)
+	if err := checkSize(canvas.Root.Width, canvas.Root.Height); err != nil {
+		return nil, err
+	}
+
+	return canvas, nil
+}
+
+// maxPixels is the most pixels an image.RGBA, at 4 bytes a pixel, can address.
+const maxPixels = int64(^uint(0)>>1) / 4
+
+// checkSize returns an error when a w x h image would be too large to address.
+func checkSize(w, h int) error {
+	if w > 0 && int64(h) > maxPixels/int64(w) {
+		return errorf(LayoutError, "", nil, "a %dx%d canvas is more than the %d pixels an image can hold", w, h, maxPixels)
+	}
 
-	// compute area of the shapes to determine best layout below
-	blockArea := 0
+	return nil
+}
 
-	// Copy each list of blocks so they can be packed most efficiently.
-	// Since we're dealing with a slice of pointers, seems like we have
-	// to copy each struct.  Is there a better way??
-	byWidth := make(Blocks, len(blocks))
-	byHeight := make(Blocks, len(blocks))
-	byArea := make(Blocks, len(blocks))
-	byMax := make(Blocks, len(blocks))
+// FitUsing packs blocks like Fit, trying only the given algorithms.
+func FitUsing(blocks Blocks, algorithms ...Algorithm) *Canvas {
+	return FitWith(blocks, FitOptions{Algorithms: algorithms})
+}
 
-	for i, s := range blocks {
-		byWidth[i] = &Block{Name: s.Name, Width: s.Width, Height: s.Height}
-		byHeight[i] = &Block{Name: s.Name, Width: s.Width, Height: s.Height}
-		byArea[i] = &Block{Name: s.Name, Width: s.Width, Height: s.Height}
-		byMax[i] = &Block{Name: s.Name, Width: s.Width, Height: s.Height}
+// FitSorted packs blocks like FitUsing, trying only one sort order.  With
+// LayoutByInput the blocks are packed in the order given.
+func FitSorted(blocks Blocks, layout Layout, algorithms ...Algorithm) *Canvas {
+	return FitWith(blocks, FitOptions{Algorithms: algorithms, Layouts: []Layout{layout}})
+}
+
+// FitWidth packs blocks like Fit into a canvas no wider than width (or the
+// widest block), growing only downward.  Only the given algorithms that can
+// pack into a fixed width are tried, or all of them if there are none.
+func FitWidth(blocks Blocks, width int, algorithms ...Algorithm) *Canvas {
+	return FitWith(blocks, FitOptions{Algorithms: algorithms, Width: width})
+}
+
+// defaults fills in the algorithms and layouts left empty in opts, and
+// drops any layouts not registered.
+func (opts FitOptions) defaults() FitOptions {
+	if len(opts.Algorithms) == 0 {
+		opts.Algorithms = Algorithms
+	}
 
-		blockArea += s.Width * s.Height
+	opts.Layouts = registered(opts.Layouts)
+	if len(opts.Layouts) == 0 {
+		opts.Layouts = Layouts()
 	}
 
-	// Try to layout Blocks 4 different ways.  What we have here
+	if opts.Width > 0 {
+		opts.Algorithms = fixedWidth(opts.Algorithms)
+	}
+
+	if opts.Scorer == nil {
+		opts.Scorer = WasteScorer{}
+	}
+
+	return opts
+}
+
+// registered returns the layouts that have been registered.
+func registered(layouts []Layout) []Layout {
+	var valid []Layout
+	for _, l := range layouts {
+		if l.valid() {
+			valid = append(valid, l)
+		}
+	}
+
+	return valid
+}
+
+// fixedWidth returns the algorithms that can pack into a fixed width, or
+// all of those in Algorithms if there are none.
+func fixedWidth(algorithms []Algorithm) []Algorithm {
+	var fixed []Algorithm
+	for _, a := range algorithms {
+		if a.FixedWidth() {
+			fixed = append(fixed, a)
+		}
+	}
+
+	if len(fixed) == 0 {
+		for _, a := range Algorithms {
+			if a.FixedWidth() {
+				fixed = append(fixed, a)
+			}
+		}
+	}
+
+	return fixed
+}
+
+// FitCandidates packs blocks like FitWith, also returning every candidate
+// canvas tried, ordered by algorithm and then layout, for reports.
+func FitCandidates(blocks Blocks, opts FitOptions) (*Canvas, []Candidate) {
+	return fitCandidates(blocks, opts.defaults())
+}
+
+// fitAll packs blocks with every algorithm and layout in opts, keeping the
+// canvas with the lowest score once padded to the sizing of opts.
+func fitAll(blocks Blocks, opts FitOptions) *Canvas {
+	canvas, _ := fitCandidates(blocks, opts)
+	return canvas
+}
+
+// fitCandidates packs blocks like fitAll, also describing every canvas tried.
+func fitCandidates(blocks Blocks, opts FitOptions) (*Canvas, []Candidate) {
+	best, canvi := fitCanvases(blocks, opts)
+
+	candidates := make([]Candidate, len(canvi))
+	for i, c := range canvi {
+		candidates[i] = c.Candidate()
+		candidates[i].Chosen = c == best
+	}
+
+	return best, candidates
+}
+
+// fitCanvases packs blocks like fitAll, also returning every canvas tried,
+// ordered by algorithm and then layout.
+func fitCanvases(blocks Blocks, opts FitOptions) (*Canvas, []*Canvas) {
+	if len(blocks) == 0 {
+		canvas := &Canvas{Root: NewBlock("#root#", 0, 0)}
+		canvas.Score = opts.Scorer.Score(canvas)
+
+		return canvas, nil
+	}
+
+	// Try to layout Blocks every way.  What we have here
 	// is an "embarrassingly parallel" problem, the easiest kind
 	// to perform concurrently
 	ch := make(chan *Canvas)
 
 	// Canvi ... canvases
-	numCanvi := 4
-
-	// TODO DANGER what if we're laying huge, int64 range area here
-	// Should we just use int64 everywhere instead of int ??
-	minWaste := 1<<31 - 1
-
-	go layoutCanvas(ch, byWidth, LayoutByWidth)
-	go layoutCanvas(ch, byHeight, LayoutByHeight)
-	go layoutCanvas(ch, byArea, LayoutByArea)
-	go layoutCanvas(ch, byMax, LayoutByMax)
+	numCanvi := 0
+
+	for _, algorithm := range opts.Algorithms {
+		for _, layout := range opts.Layouts {
+			// Copy each list of blocks so they can be packed independently.
+			copied := make(Blocks, len(blocks))
+			for i, s := range blocks {
+				copied[i] = NewBlock(s.Name, s.Width, s.Height)
+			}
+
+			go layoutCanvas(ch, copied, algorithm, layout, opts.Width)
+			numCanvi++
+		}
+	}
 
 	var bestCanvas *Canvas
+	canvi := make([]*Canvas, numCanvi)
 
 	for i := 0; i < numCanvi; i++ {
 		c := <-ch
-		waste := (c.Root.Width * c.Root.Height) - blockArea
-		//fmt.Printf("%s <%dx%d> has wasted %d pixels\n", c.layout, c.Root.Width, c.Root.Height, waste)
-		if waste < minWaste {
-			minWaste = waste
+		opts.Sizing.padCanvas(c)
+		c.Score = opts.Scorer.Score(c)
+		canvi[i] = c
+		// prefer the earlier algorithm and layout on a tie, whatever order the canvases arrive in
+		if bestCanvas == nil || c.Score < bestCanvas.Score || (c.Score == bestCanvas.Score && before(c, bestCanvas)) {
 			bestCanvas = c
 		}
 	}
-	//fmt.Println("USING ", bestCanvas.layout)
 
-	return bestCanvas
+	sort.Slice(canvi, func(i, j int) bool { return before(canvi[i], canvi[j]) })
+
+	return bestCanvas, canvi
 }
 
-func layoutCanvas(ch chan<- *Canvas, blocks Blocks, layout Layout) {
-	switch layout {
-	case LayoutByWidth:
-		sort.Sort(BlocksByWidth(blocks))
-	case LayoutByHeight:
-		sort.Sort(BlocksByHeight(blocks))
-	case LayoutByArea:
-		sort.Sort(BlocksByArea(blocks))
-	default:
-		sort.Sort(BlocksByMax(blocks))
+// before reports whether canvas a was packed by an earlier algorithm and layout than b.
+func before(a, b *Canvas) bool {
+	if a.Algorithm != b.Algorithm {
+		return a.Algorithm < b.Algorithm
 	}
 
-	canvas := fit(blocks)
-	canvas.layout = layout
+	return a.Layout < b.Layout
+}
+
+func layoutCanvas(ch chan<- *Canvas, blocks Blocks, algorithm Algorithm, layout Layout, width int) {
+	layout.sort(blocks)
+
+	canvas := algorithm.pack(blocks, width)
+	canvas.Algorithm = algorithm
+	canvas.Layout = layout
 	ch <- canvas
 }
 
 // Fit blocks in a rectangle.  Blocks must be sorted before calling Fit.  It's
-// easiest to call BestFit which calls this method with 4 different sorts to
-// determine the tightest packing.
+// easiest to call Fit which calls this method with every registered layout
+// to determine the tightest packing.
 func fit(blocks Blocks) *Canvas {
 
 	root := &Block{Width: blocks[0].Width, Height: blocks[0].Height}
@@ -154,12 +316,12 @@ func (c *Canvas) growNode(w, h int) *Block {
 		return c.growDown(w, h)
 	} else if canGrowRight {
 		return c.growRight(w, h)
-	} else if canGrowDown {
-		return c.growDown(w, h)
 	}
 
-	// need to ensure sensible root starting size to avoid this happening
-	return nil
+	// Growing down also works when the block is wider than the root, which
+	// happens when the blocks are not sorted largest first.  The root is
+	// widened, leaving some space to the right of it unused.
+	return c.growDown(w, h)
 }
 
 // duplicate block ... is a deep copy needed here????
@@ -184,9 +346,10 @@ func (c *Canvas) growRight(w, h int) *Block {
 }
 
 func (c *Canvas) growDown(w, h int) *Block {
-	newRoot := &Block{Width: c.Root.Width, Height: c.Root.Height + h}
+	width := max(c.Root.Width, w)
+	newRoot := &Block{Width: width, Height: c.Root.Height + h}
 	newRoot.used = true
-	newRoot.down = &Block{X: 0, Y: c.Root.Height, Width: c.Root.Width, Height: h}
+	newRoot.down = &Block{X: 0, Y: c.Root.Height, Width: width, Height: h}
 	newRoot.right = dup(c.Root)
 
 	c.Root = newRoot
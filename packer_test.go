@@ -0,0 +1,395 @@
+package packer
+
+import (
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+func getManyBlocks() Blocks {
+	var blocks Blocks
+	for i := 0; i < 40; i++ {
+		blocks = append(blocks, NewBlock(fmt.Sprintf("b%d", i), 5+(i*7)%31, 3+(i*11)%23))
+	}
+
+	return blocks
+}
+
+func checkCanvas(t *testing.T, name string, blocks Blocks, canvas *Canvas) {
+	if len(canvas.Blocks) != len(blocks) {
+		t.Fatalf("%s placed %d of %d blocks", name, len(canvas.Blocks), len(blocks))
+	}
+
+	for i, a := range canvas.Blocks {
+		if a.X < 0 || a.Y < 0 || a.X+a.Width > canvas.Root.Width || a.Y+a.Height > canvas.Root.Height {
+			t.Errorf("%s placed %s outside of %s", name, a, canvas)
+		}
+
+		for _, b := range canvas.Blocks[i+1:] {
+			if intersects(a, b) {
+				t.Errorf("%s overlapped %s and %s", name, a, b)
+			}
+		}
+	}
+}
+
+func TestFitUsing(t *testing.T) {
+	blocks := getManyBlocks()
+	for _, a := range Algorithms {
+		checkCanvas(t, a.String(), blocks, FitUsing(blocks, a))
+	}
+}
+
+func TestFit(t *testing.T) {
+	blocks := getManyBlocks()
+	canvas := Fit(blocks)
+	checkCanvas(t, "Fit", blocks, canvas)
+
+	// every algorithm was tried, so none can beat the result
+	for _, a := range Algorithms {
+		c := FitUsing(blocks, a)
+		if c.Root.Width*c.Root.Height < canvas.Root.Width*canvas.Root.Height {
+			t.Errorf("%s packed %s, tighter than Fit %s", a, c, canvas)
+		}
+	}
+}
+
+func TestFitWidth(t *testing.T) {
+	blocks := getManyBlocks()
+	for _, a := range Algorithms {
+		if !a.FixedWidth() {
+			continue
+		}
+
+		canvas := FitWidth(blocks, 64, a)
+		checkCanvas(t, a.String(), blocks, canvas)
+		if canvas.Root.Width > 64 {
+			t.Errorf("%s packed %s, wider than 64", a, canvas)
+		}
+	}
+}
+
+func TestFitSortedInput(t *testing.T) {
+	// smallest first, so the binary tree must grow to hold wider blocks
+	blocks := Blocks{NewBlock("a", 2, 2), NewBlock("b", 10, 3), NewBlock("c", 3, 10), NewBlock("d", 20, 20)}
+	for _, a := range Algorithms {
+		checkCanvas(t, a.String(), blocks, FitSorted(blocks, LayoutByInput, a))
+	}
+
+	checkCanvas(t, "input", getManyBlocks(), FitSorted(getManyBlocks(), LayoutByInput))
+}
+
+func TestFitSortedStrip(t *testing.T) {
+	blocks := getManyBlocks()
+	for _, a := range []Algorithm{AlgorithmVertical, AlgorithmHorizontal} {
+		canvas := FitSorted(blocks, LayoutByInput, a)
+		checkCanvas(t, a.String(), blocks, canvas)
+
+		for i, b := range canvas.Blocks {
+			if b.Name != blocks[i].Name {
+				t.Fatalf("%s placed %s at %d, expected %s", a, b.Name, i, blocks[i].Name)
+			}
+
+			if (a == AlgorithmVertical && b.X != 0) || (a == AlgorithmHorizontal && b.Y != 0) {
+				t.Errorf("%s placed %s out of line", a, b)
+			}
+		}
+	}
+}
+
+func TestFitBins(t *testing.T) {
+	blocks := getManyBlocks()
+	pages := FitBins(blocks, 64, 64)
+	if len(pages) < 2 {
+		t.Fatalf("FitBins packed %d pages, expected several", len(pages))
+	}
+
+	placed := 0
+	for i, page := range pages {
+		name := fmt.Sprintf("page %d", i)
+		if page.Root.Width > 64 || page.Root.Height > 64 {
+			t.Errorf("%s is %s, larger than 64x64", name, page)
+		}
+
+		checkCanvas(t, name, page.Blocks, page)
+		placed += len(page.Blocks)
+	}
+
+	if placed != len(blocks) {
+		t.Errorf("FitBins placed %d of %d blocks", placed, len(blocks))
+	}
+}
+
+func TestFitWithSizing(t *testing.T) {
+	blocks := getManyBlocks()
+	for _, s := range []Sizing{{PowerOfTwo: true}, {Multiple: 4}, {PowerOfTwo: true, Multiple: 8}} {
+		canvas := FitWith(blocks, FitOptions{Sizing: s})
+		name := fmt.Sprintf("%+v", s)
+		checkCanvas(t, name, blocks, canvas)
+
+		for _, v := range []int{canvas.Root.Width, canvas.Root.Height} {
+			if s.Multiple > 1 && v%s.Multiple != 0 {
+				t.Errorf("%s packed %s, not a multiple of %d", name, canvas, s.Multiple)
+			}
+
+			if s.PowerOfTwo && v&(v-1) != 0 {
+				t.Errorf("%s packed %s, not a power of two", name, canvas)
+			}
+		}
+	}
+}
+
+func TestFitWithScorer(t *testing.T) {
+	blocks := getManyBlocks()
+	waste := Fit(blocks)
+	if waste.Score != float64(waste.Waste()) {
+		t.Errorf("Fit scored %s %v, expected its waste %d", waste, waste.Score, waste.Waste())
+	}
+
+	narrow := FitWith(blocks, FitOptions{Scorer: WidthScorer{}})
+	checkCanvas(t, "WidthScorer", blocks, narrow)
+	if narrow.Root.Width > waste.Root.Width {
+		t.Errorf("WidthScorer packed %s, wider than %s", narrow, waste)
+	}
+
+	wide := FitWith(blocks, FitOptions{Scorer: AspectScorer{Ratio: 4}})
+	checkCanvas(t, "AspectScorer", blocks, wide)
+	if wide.Root.Width <= wide.Root.Height {
+		t.Errorf("AspectScorer packed %s, expected a wide canvas", wide)
+	}
+}
+
+func TestRegisterLayout(t *testing.T) {
+	reverse := func(blocks Blocks) sort.Interface {
+		return sort.Reverse(BlocksByWidth(blocks))
+	}
+
+	// leave the registry as it was for the other tests
+	layoutsMu.RLock()
+	registered := append([]layoutEntry(nil), layouts...)
+	layoutsMu.RUnlock()
+	defer func() {
+		layoutsMu.Lock()
+		layouts = registered
+		layoutsMu.Unlock()
+	}()
+
+	l := RegisterLayout("test-reverse-width", reverse)
+	if l.String() != "test-reverse-width" {
+		t.Errorf("registered layout is called %q", l)
+	}
+
+	if again := RegisterLayout("test-reverse-width", reverse); again != l {
+		t.Errorf("registering again returned %v, expected %v", again, l)
+	}
+
+	found := false
+	for _, o := range Layouts() {
+		found = found || o == l
+	}
+
+	if !found {
+		t.Error("registered layout is not in Layouts")
+	}
+
+	blocks := getManyBlocks()
+	checkCanvas(t, l.String(), blocks, FitSorted(blocks, l))
+
+	// layouts never registered are skipped
+	blocks = getManyBlocks()
+	checkCanvas(t, "Layout(99)", blocks, FitSorted(blocks, Layout(99)))
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a nil sorter did not panic")
+		}
+	}()
+
+	RegisterLayout("test-nil", nil)
+}
+
+func TestFitOptimized(t *testing.T) {
+	blocks := getManyBlocks()
+	start := Fit(blocks)
+
+	for _, rotate := range []bool{false, true} {
+		canvas := FitOptimized(blocks, OptimizeOptions{Duration: 50 * time.Millisecond, Rotate: rotate})
+		if canvas.Score > start.Score {
+			t.Errorf("FitOptimized scored %v, worse than Fit %v", canvas.Score, start.Score)
+		}
+
+		if len(canvas.Blocks) != len(blocks) {
+			t.Fatalf("FitOptimized placed %d of %d blocks", len(canvas.Blocks), len(blocks))
+		}
+
+		for i, a := range canvas.Blocks {
+			if a.Rotated && !rotate {
+				t.Errorf("FitOptimized turned %s", a)
+			}
+
+			for _, b := range canvas.Blocks[i+1:] {
+				if intersects(a, b) {
+					t.Errorf("FitOptimized overlapped %s and %s", a, b)
+				}
+			}
+		}
+	}
+
+	// blocks in input order, packed into shelves, leave plenty to improve
+	opts := OptimizeOptions{
+		FitOptions: FitOptions{Algorithms: []Algorithm{AlgorithmShelf}, Layouts: []Layout{LayoutByInput}},
+		Iterations: 2000,
+		Seed:       7,
+	}
+
+	poor := FitWith(getManyBlocks(), opts.FitOptions)
+	first := FitOptimized(getManyBlocks(), opts)
+	if first.Score >= poor.Score {
+		t.Errorf("FitOptimized scored %v, no better than %v", first.Score, poor.Score)
+	}
+
+	// nothing to search
+	if canvas := FitOptimized(Blocks{}, OptimizeOptions{Rotate: true, Iterations: 3}); len(canvas.Blocks) != 0 {
+		t.Errorf("FitOptimized of no blocks placed %d", len(canvas.Blocks))
+	}
+
+	// turned, these tall blocks pack tighter into a wider canvas, but they
+	// are never turned wider than a fixed width
+	var tall Blocks
+	for i, size := range [][2]int{{3, 22}, {3, 28}, {5, 21}, {13, 26}, {3, 23}, {6, 24}, {4, 23}, {12, 28}, {5, 28}, {9, 25}} {
+		tall = append(tall, NewBlock(fmt.Sprintf("t%d", i), size[0], size[1]))
+	}
+
+	narrow := FitOptimized(tall, OptimizeOptions{FitOptions: FitOptions{Width: 20}, Rotate: true, Iterations: 2000})
+	if narrow.Root.Width > 20 {
+		t.Errorf("FitOptimized made a canvas %d wide, wider than 20", narrow.Root.Width)
+	}
+
+	for _, b := range narrow.Blocks {
+		if b.X+b.Width > 20 {
+			t.Errorf("FitOptimized placed %s beyond the width of 20", b)
+		}
+	}
+
+	// limited only by iterations, the search finds the same canvas every time
+	for i := 0; i < 3; i++ {
+		again := FitOptimized(getManyBlocks(), opts)
+		if again.Score != first.Score || len(again.Blocks) != len(first.Blocks) {
+			t.Fatalf("FitOptimized run %d found %v, not %v", i, again, first)
+		}
+
+		for j, b := range again.Blocks {
+			if f := first.Blocks[j]; b.Name != f.Name || b.X != f.X || b.Y != f.Y || b.Rotated != f.Rotated {
+				t.Fatalf("FitOptimized run %d placed %s, not %s", i, b, first.Blocks[j])
+			}
+		}
+	}
+}
+
+func TestTryFit(t *testing.T) {
+	canvas, err := TryFit(nil, FitOptions{})
+	if err != nil || canvas.Root.Width != 0 || canvas.Root.Height != 0 || len(canvas.Blocks) != 0 {
+		t.Errorf("TryFit of no blocks returned %v, %v", canvas, err)
+	}
+
+	zero := Blocks{NewBlock("a", 0, 0), NewBlock("b", 10, 0), NewBlock("c", 4, 6)}
+	canvas, err = TryFit(zero, FitOptions{})
+	if err != nil {
+		t.Fatalf("TryFit of zero size blocks failed, %v", err)
+	}
+
+	checkCanvas(t, "zero size", zero, canvas)
+
+	if _, err = TryFit(Blocks{NewBlock("a", -1, 5)}, FitOptions{}); err == nil {
+		t.Error("TryFit of a negative size block succeeded")
+	}
+
+	huge := int(^uint(0)>>1) / 2
+	if _, err = TryFit(Blocks{NewBlock("a", huge, huge)}, FitOptions{}); err == nil {
+		t.Error("TryFit of a huge block succeeded")
+	}
+}
+
+func TestFitBinaryTreeUnsorted(t *testing.T) {
+	// smallest first, so the tree cannot grow from a root the size of the first block
+	blocks := Blocks{NewBlock("a", 2, 2), NewBlock("b", 10, 3), NewBlock("c", 3, 10), NewBlock("d", 20, 20)}
+	canvas, err := TryFit(blocks, FitOptions{Algorithms: []Algorithm{AlgorithmBinaryTree}, Layouts: []Layout{LayoutByInput}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkCanvas(t, "unsorted binary tree", blocks, canvas)
+}
+
+func TestFitStable(t *testing.T) {
+	blocks := getManyBlocks()
+	previous := Fit(blocks)
+
+	// resize one block, remove another and add two more
+	changed := append(Blocks{}, blocks[1:]...)
+	changed[0] = NewBlock(changed[0].Name, 50, 40)
+	changed = append(changed, NewBlock("new1", 17, 9), NewBlock("new2", 60, 60))
+
+	canvas := FitStable(changed, StableOptions{Previous: previous.Blocks})
+	checkCanvas(t, "FitStable", changed, canvas)
+
+	old := make(map[string]*Block)
+	for _, b := range previous.Blocks {
+		old[b.Name] = b
+	}
+
+	for i, b := range canvas.Blocks {
+		if b.Name != changed[i].Name {
+			t.Fatalf("FitStable placed %s at %d, not %s", b, i, changed[i].Name)
+		}
+
+		if p, ok := old[b.Name]; ok && i > 0 && (b.X != p.X || b.Y != p.Y) {
+			t.Errorf("FitStable moved %s from %s", b, p)
+		}
+	}
+
+	// any waste is too much, so everything is packed afresh
+	repacked := FitStable(changed, StableOptions{Previous: previous.Blocks, MaxWaste: 0.0001})
+	fresh := Fit(changed)
+	if repacked.Root.Width != fresh.Root.Width || repacked.Root.Height != fresh.Root.Height {
+		t.Errorf("FitStable repacked into %s, not %s", repacked, fresh)
+	}
+}
+
+func TestFitCandidates(t *testing.T) {
+	blocks := getManyBlocks()
+	canvas, candidates := FitCandidates(blocks, FitOptions{})
+
+	if want := len(Algorithms) * len(Layouts()); len(candidates) != want {
+		t.Fatalf("FitCandidates returned %d candidates, not %d", len(candidates), want)
+	}
+
+	chosen := 0
+	for _, c := range candidates {
+		if c.Fill <= 0 || c.Fill > 100 {
+			t.Errorf("candidate %s %s is %v%% filled", c.Algorithm, c.Layout, c.Fill)
+		}
+
+		if c.Score < canvas.Score {
+			t.Errorf("candidate %s %s scored %v, better than the chosen %v", c.Algorithm, c.Layout, c.Score, canvas.Score)
+		}
+
+		if c.Chosen {
+			chosen++
+			want := canvas.Candidate()
+			want.Chosen = true
+			if c != want || c.Algorithm != canvas.Algorithm.Name() || c.Layout != canvas.Layout.String() {
+				t.Errorf("chosen candidate %v does not describe %s", c, canvas)
+			}
+		}
+	}
+
+	if chosen != 1 {
+		t.Errorf("FitCandidates chose %d candidates", chosen)
+	}
+
+	if candidates[0].Algorithm != AlgorithmBinaryTree.Name() || candidates[0].Layout != LayoutByWidth.String() {
+		t.Errorf("first candidate is %s %s", candidates[0].Algorithm, candidates[0].Layout)
+	}
+}
@@ -0,0 +1,63 @@
+package packer
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Processor describes a stylesheet language the sprite's styles are written in.
+type Processor struct {
+	// Template is the text/template source executed with the stylesheet data.
+	Template string
+	// Extension is the file extension of the stylesheet, without the leading dot.
+	Extension string
+}
+
+var (
+	processorsMu sync.RWMutex
+	processors   = map[string]*Processor{
+		"css":    {Template: CSSTemplate, Extension: "css"},
+		"scss":   {Template: SCSSTemplate, Extension: "scss"},
+		"sass":   {Template: SassTemplate, Extension: "sass"},
+		"less":   {Template: LESSTemplate, Extension: "less"},
+		"stylus": {Template: StylusTemplate, Extension: "styl"},
+	}
+)
+
+// RegisterProcessor makes a stylesheet processor available by name,
+// replacing any processor previously registered with that name.
+func RegisterProcessor(name string, p *Processor) {
+	processorsMu.Lock()
+	defer processorsMu.Unlock()
+
+	processors[name] = p
+}
+
+// LookupProcessor returns the stylesheet processor registered with name.
+func LookupProcessor(name string) (*Processor, error) {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+
+	p, ok := processors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown stylesheet processor %q", name)
+	}
+
+	return p, nil
+}
+
+// Processors returns the names of all registered stylesheet processors, sorted.
+func Processors() []string {
+	processorsMu.RLock()
+	defer processorsMu.RUnlock()
+
+	names := make([]string, 0, len(processors))
+	for name := range processors {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
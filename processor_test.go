@@ -0,0 +1,72 @@
+package packer
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	dir, done := tempDir(t)
+	defer done()
+
+	files := writeImages(t, dir, 2)
+
+	for _, tc := range []struct {
+		processor string
+		extension string
+		want      []string
+	}{
+		{"css", "css", []string{".sprite_icon00 {\n  background-position: "}},
+		{"scss", "scss", []string{"$sprite-icon00-x: ", "$sprite-icon01-height: ", "$sprite-icon01: ", "@include sprite-position($sprite-icon00);"}},
+		{"sass", "sass", []string{"$sprite-icon00-x: ", "$sprite-icon01-height: ", "$sprite-icon01: ", "+sprite-position($sprite-icon00)"}},
+		{"less", "less", []string{"@sprite-icon00-x: ", "@sprite-icon01-height: ", "@sprite-icon01: ", ".sprite-position(@sprite-icon00);"}},
+		{"stylus", "styl", []string{"$sprite-icon00-x = ", "$sprite-icon01-height = ", "$sprite-icon01 = ", "sprite-position($sprite-icon00)"}},
+	} {
+		c, sprite := createSprite(t, files, func(c *Config) {
+			c.Processor = tc.processor
+			c.CSSPath = dir
+		})
+
+		if fn := c.stylesheetFile(); fn != filepath.Join(dir, "sprite."+tc.extension) {
+			t.Errorf("%s stylesheet is written to %s", tc.processor, fn)
+		}
+
+		for _, want := range tc.want {
+			if !strings.Contains(sprite.Stylesheet, want) {
+				t.Errorf("%s stylesheet lacks %q:\n%s", tc.processor, want, sprite.Stylesheet)
+			}
+		}
+	}
+}
+
+func TestRegisterProcessor(t *testing.T) {
+	if _, err := LookupProcessor("test-none"); err == nil {
+		t.Error("found an unregistered processor")
+	}
+
+	p := &Processor{Template: "{{range .Images}}{{.Var}};{{end}}", Extension: "txt"}
+	RegisterProcessor("test-list", p)
+
+	if got, err := LookupProcessor("test-list"); err != nil || got != p {
+		t.Errorf("LookupProcessor returned %v, %v", got, err)
+	}
+
+	names := Processors()
+	if !reflect.DeepEqual(names[:2], []string{"css", "less"}) {
+		t.Errorf("Processors are not sorted, %v", names)
+	}
+
+	dir, done := tempDir(t)
+	defer done()
+
+	_, sprite := createSprite(t, writeImages(t, dir, 2), func(c *Config) {
+		c.Processor = "test-list"
+	})
+
+	// images are listed in the order they were packed
+	if len(sprite.Stylesheet) != 28 || !strings.Contains(sprite.Stylesheet, "sprite-icon00;") || !strings.Contains(sprite.Stylesheet, "sprite-icon01;") {
+		t.Errorf("registered processor wrote %q", sprite.Stylesheet)
+	}
+}
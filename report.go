@@ -0,0 +1,65 @@
+package packer
+
+// Candidate describes a canvas packed while looking for the best one, for
+// reports on how well blocks were packed.
+type Candidate struct {
+	Algorithm string  `json:"algorithm"`
+	Layout    string  `json:"layout"`
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Waste     int64   `json:"waste"`
+	Fill      float64 `json:"fill"`
+	Score     float64 `json:"score"`
+	// Chosen is set on the candidate that was kept.
+	Chosen bool `json:"chosen"`
+}
+
+// Candidate describes the canvas as a candidate.
+func (c *Canvas) Candidate() Candidate {
+	return Candidate{
+		Algorithm: c.Algorithm.Name(),
+		Layout:    c.Layout.String(),
+		Width:     c.Root.Width,
+		Height:    c.Root.Height,
+		Waste:     c.Waste(),
+		Fill:      c.Fill(),
+		Score:     c.Score,
+	}
+}
+
+// Stats describes how well the images of a sprite were packed, in 1x
+// pixels including margins.
+type Stats struct {
+	Name   string `json:"name"`
+	Images int    `json:"images"`
+	Pages  int    `json:"pages"`
+	// Width and Height are the size of the sprite, the largest of its pages.
+	Width  int     `json:"width"`
+	Height int     `json:"height"`
+	Waste  int64   `json:"waste"`
+	Fill   float64 `json:"fill"`
+	// Candidates are the canvases tried when packing, only the one chosen
+	// when the sprite kept its last layout or searched for a better one,
+	// and the pages, all chosen, when it was split across several.
+	Candidates []Candidate `json:"candidates"`
+}
+
+// newStats describes the canvases packed for a sprite.
+func newStats(name string, canvases []*Canvas, candidates []Candidate) *Stats {
+	s := &Stats{Name: name, Pages: len(canvases), Candidates: candidates}
+
+	var area int64
+	for _, c := range canvases {
+		s.Images += len(c.Blocks)
+		s.Width = max(s.Width, c.Root.Width)
+		s.Height = max(s.Height, c.Root.Height)
+		s.Waste += c.Waste()
+		area += int64(c.Root.Width) * int64(c.Root.Height)
+	}
+
+	if area > 0 {
+		s.Fill = float64(area-s.Waste) * 100 / float64(area)
+	}
+
+	return s
+}
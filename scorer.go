@@ -0,0 +1,103 @@
+package packer
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Scorer rates a packed canvas.  Fit keeps the candidate with the lowest
+// score, preferring the earlier algorithm and layout on a tie.
+type Scorer interface {
+	Score(c *Canvas) float64
+}
+
+// WasteScorer scores a canvas by its wasted pixels, the default.
+type WasteScorer struct{}
+
+// Score returns the number of pixels not covered by a block.
+func (WasteScorer) Score(c *Canvas) float64 {
+	return float64(c.Waste())
+}
+
+// SquareScorer prefers square canvases, scoring the longer side divided by
+// the shorter one.
+type SquareScorer struct{}
+
+// Score returns the ratio of the longer side to the shorter one.
+func (SquareScorer) Score(c *Canvas) float64 {
+	return aspect(c, 1)
+}
+
+// AspectScorer prefers canvases whose width divided by height is close to Ratio.
+type AspectScorer struct {
+	Ratio float64
+}
+
+// Score returns how many times the aspect ratio of c differs from Ratio,
+// 1 when it matches.
+func (s AspectScorer) Score(c *Canvas) float64 {
+	return aspect(c, s.Ratio)
+}
+
+// WidthScorer prefers the narrowest canvas.
+type WidthScorer struct{}
+
+// Score returns the width of c.
+func (WidthScorer) Score(c *Canvas) float64 {
+	return float64(c.Root.Width)
+}
+
+// aspect returns how many times the aspect ratio of c differs from ratio.
+func aspect(c *Canvas, ratio float64) float64 {
+	if c.Root.Width == 0 || c.Root.Height == 0 || ratio <= 0 {
+		return math.Inf(1)
+	}
+
+	r := float64(c.Root.Width) / float64(c.Root.Height) / ratio
+	if r < 1 {
+		r = 1 / r
+	}
+
+	return r
+}
+
+// scorerNames are the names used to select a scorer in the config and CLI
+var scorerNames = map[string]Scorer{
+	"waste":  WasteScorer{},
+	"square": SquareScorer{},
+	"width":  WidthScorer{},
+}
+
+// ParseScorer returns the scorer with the given name: waste, square, width,
+// or aspect:<ratio> such as "aspect:2" for canvases twice as wide as tall.
+func ParseScorer(name string) (Scorer, error) {
+	if s, ok := scorerNames[name]; ok {
+		return s, nil
+	}
+
+	if strings.HasPrefix(name, "aspect:") {
+		r, err := strconv.ParseFloat(strings.TrimPrefix(name, "aspect:"), 64)
+		if err != nil || r <= 0 || math.IsInf(r, 0) {
+			return nil, fmt.Errorf("illegal aspect ratio in %q", name)
+		}
+
+		return AspectScorer{Ratio: r}, nil
+	}
+
+	return nil, fmt.Errorf("unknown scorer %q", name)
+}
+
+// ScorerNames returns the names of all scorers, sorted.
+func ScorerNames() []string {
+	names := []string{"aspect:<ratio>"}
+	for name := range scorerNames {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,50 @@
+package packer
+
+// shelf is a row of blocks sharing the same top edge.
+type shelf struct {
+	y      int
+	height int
+	// used is the width of the shelf already filled
+	used int
+}
+
+// fitShelf packs the blocks, in order, onto shelves in a bin of the given
+// width that only grows downward.  Each block goes on the shelf whose height
+// it fills best, and a new shelf is opened below the others when none has
+// room.  Shelves suit blocks of similar heights, sorted by height.
+func fitShelf(blocks Blocks, width int) *Canvas {
+	width = binWidth(blocks, width)
+
+	var shelves []*shelf
+	bottom := 0
+
+	root := NewBlock("#root#", 0, 0)
+	placed := make(Blocks, len(blocks))
+	for i, b := range blocks {
+		var best *shelf
+		for _, s := range shelves {
+			if s.used+b.Width > width || b.Height > s.height {
+				continue
+			}
+
+			if best == nil || s.height < best.height {
+				best = s
+			}
+		}
+
+		if best == nil {
+			best = &shelf{y: bottom, height: b.Height}
+			shelves = append(shelves, best)
+			bottom += b.Height
+		}
+
+		node := &Block{Name: b.Name, X: best.used, Y: best.y, Width: b.Width, Height: b.Height}
+		best.used += b.Width
+		placed[i] = node
+
+		root.Width = max(root.Width, node.X+node.Width)
+		root.Height = max(root.Height, node.Y+node.Height)
+	}
+
+	return &Canvas{Root: root, Blocks: placed}
+}
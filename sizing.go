@@ -0,0 +1,62 @@
+package packer
+
+// Sizing constrains the dimensions of a canvas, for textures that must be
+// a power of two or a multiple of the block size of a compressed format.
+// Fit scores every candidate by its padded size, so the constraint takes
+// part in choosing the layout rather than wasting space afterwards.
+type Sizing struct {
+	// PowerOfTwo rounds both dimensions up to a power of two.
+	PowerOfTwo bool
+	// Multiple rounds both dimensions up to a multiple of it, when above 1.
+	Multiple int
+}
+
+// pad rounds the length v up to the nearest length allowed by s.
+func (s Sizing) pad(v int) int {
+	if v <= 0 {
+		return v
+	}
+
+	if s.Multiple > 1 {
+		v = (v + s.Multiple - 1) / s.Multiple * s.Multiple
+	}
+
+	if s.PowerOfTwo {
+		p := 1
+		for p < v {
+			p *= 2
+		}
+
+		v = p
+	}
+
+	return v
+}
+
+// floor rounds the length v down to the nearest length allowed by s, or 0
+// if there is none.
+func (s Sizing) floor(v int) int {
+	if s.Multiple > 1 {
+		v = v / s.Multiple * s.Multiple
+	}
+
+	if s.PowerOfTwo && v > 0 {
+		p := 1
+		for p*2 <= v {
+			p *= 2
+		}
+
+		v = p
+		if s.Multiple > 1 && v%s.Multiple != 0 {
+			v = 0
+		}
+	}
+
+	return v
+}
+
+// padCanvas grows the root of canvas to the padded size.
+func (s Sizing) padCanvas(canvas *Canvas) {
+	canvas.Root.Width = s.pad(canvas.Root.Width)
+	canvas.Root.Height = s.pad(canvas.Root.Height)
+}
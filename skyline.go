@@ -0,0 +1,94 @@
+package packer
+
+import "math"
+
+// segment is a horizontal piece of the skyline, the top edge of the space
+// already filled below it.
+type segment struct {
+	x     int
+	y     int
+	width int
+}
+
+// fitSkyline packs the blocks, in order, into a bin of the given width that
+// only grows downward.  Each block goes where its top edge is lowest, then
+// furthest left, keeping the skyline low and flat.
+func fitSkyline(blocks Blocks, width int) *Canvas {
+	width = binWidth(blocks, width)
+	skyline := []segment{{x: 0, y: 0, width: width}}
+
+	root := NewBlock("#root#", 0, 0)
+	placed := make(Blocks, len(blocks))
+	for i, b := range blocks {
+		best, bestY := -1, math.MaxInt32
+		for j := range skyline {
+			if y, ok := skylineFit(skyline, j, b.Width, width); ok && y < bestY {
+				best, bestY = j, y
+			}
+		}
+
+		node := &Block{Name: b.Name, X: skyline[best].x, Y: bestY, Width: b.Width, Height: b.Height}
+		skyline = addSkyline(skyline, best, node)
+		placed[i] = node
+
+		root.Width = max(root.Width, node.X+node.Width)
+		root.Height = max(root.Height, node.Y+node.Height)
+	}
+
+	return &Canvas{Root: root, Blocks: placed}
+}
+
+// skylineFit returns the y of a block w wide placed at the start of
+// skyline[i], resting on the highest segment beneath it.
+func skylineFit(skyline []segment, i, w, width int) (int, bool) {
+	x := skyline[i].x
+	if x+w > width {
+		return 0, false
+	}
+
+	y := skyline[i].y
+	for j := i; j < len(skyline) && skyline[j].x < x+w; j++ {
+		y = max(y, skyline[j].y)
+	}
+
+	return y, true
+}
+
+// addSkyline raises the skyline under node, which was placed at the start of
+// skyline[i], to the bottom of node.
+func addSkyline(skyline []segment, i int, node *Block) []segment {
+	if node.Width == 0 {
+		return skyline
+	}
+
+	right := node.X + node.Width
+
+	updated := append([]segment{}, skyline[:i]...)
+	updated = append(updated, segment{x: node.X, y: node.Y + node.Height, width: node.Width})
+	for _, s := range skyline[i:] {
+		if s.x+s.width <= right {
+			// hidden under node
+			continue
+		}
+
+		if s.x < right {
+			s.width -= right - s.x
+			s.x = right
+		}
+
+		updated = append(updated, s)
+	}
+
+	// merge neighbours of the same height
+	merged := updated[:1]
+	for _, s := range updated[1:] {
+		last := &merged[len(merged)-1]
+		if last.y == s.y {
+			last.width += s.width
+		} else {
+			merged = append(merged, s)
+		}
+	}
+
+	return merged
+}
@@ -1,6 +1,7 @@
 package packer
 
-// BlocksByWidth is used to sort images by width (then height if width is same)
+// BlocksByWidth is used to sort images by width (then height if width is same).
+// Like every sort here, ties are broken by name.
 type BlocksByWidth Blocks
 
 func (s BlocksByWidth) Len() int      { return len(s) }
@@ -12,6 +13,11 @@ func (s BlocksByWidth) Less(i, j int) bool {
 		diff = s[i].Height - s[j].Height
 	}
 
+	// break ties by name, so the order never depends on the input order
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
 	return diff > 0
 }
 
@@ -27,6 +33,10 @@ func (s BlocksByHeight) Less(i, j int) bool {
 		diff = s[i].Width - s[j].Width
 	}
 
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
 	return diff > 0
 }
 
@@ -47,6 +57,10 @@ func (s BlocksByArea) Less(i, j int) bool {
 		diff = s[i].Width - s[j].Width
 	}
 
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
 	return diff > 0
 }
 
@@ -69,5 +83,70 @@ func (s BlocksByMax) Less(i, j int) bool {
 		diff = s[i].Width - s[j].Width
 	}
 
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
 	return diff > 0
 }
+
+// BlocksByPerimeter is used to sort images by perimeter (then max dimension).
+type BlocksByPerimeter Blocks
+
+func (s BlocksByPerimeter) Len() int      { return len(s) }
+func (s BlocksByPerimeter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s BlocksByPerimeter) Less(i, j int) bool {
+	diff := (s[i].Width + s[i].Height) - (s[j].Width + s[j].Height)
+	if diff == 0 {
+		diff = max(s[i].Width, s[i].Height) - max(s[j].Width, s[j].Height)
+	}
+
+	if diff == 0 {
+		diff = s[i].Height - s[j].Height
+	}
+
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
+	return diff > 0
+}
+
+// BlocksByDiagonal is used to sort images by the length of their diagonal.
+type BlocksByDiagonal Blocks
+
+func (s BlocksByDiagonal) Len() int      { return len(s) }
+func (s BlocksByDiagonal) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s BlocksByDiagonal) Less(i, j int) bool {
+	// comparing squares avoids the square root
+	diff := (s[i].Width*s[i].Width + s[i].Height*s[i].Height) - (s[j].Width*s[j].Width + s[j].Height*s[j].Height)
+	if diff == 0 {
+		diff = s[i].Height - s[j].Height
+	}
+
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
+	return diff > 0
+}
+
+// BlocksByWidthAscending is used to sort images by width, then height,
+// narrowest first.
+type BlocksByWidthAscending Blocks
+
+func (s BlocksByWidthAscending) Len() int      { return len(s) }
+func (s BlocksByWidthAscending) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s BlocksByWidthAscending) Less(i, j int) bool {
+	// Sort by (1)Width, (2)Height
+	diff := s[i].Width - s[j].Width
+	if diff == 0 {
+		diff = s[i].Height - s[j].Height
+	}
+
+	if diff == 0 {
+		return s[i].Name < s[j].Name
+	}
+
+	return diff < 0
+}
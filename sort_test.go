@@ -62,3 +62,40 @@ func TestSortByMax(t *testing.T) {
 		t.Error("Sort failed - last item")
 	}
 }
+
+func TestSortByPerimeter(t *testing.T) {
+	blocks := getBlocks()
+	sort.Sort(BlocksByPerimeter(blocks))
+	// equal perimeters, so sorted by max dimension
+	if blocks[0].Height != 30 {
+		t.Error("Sort failed - first item")
+	}
+
+	if blocks[2].Width != 20 {
+		t.Error("Sort failed - last item")
+	}
+}
+
+func TestSortByDiagonal(t *testing.T) {
+	blocks := getBlocks()
+	sort.Sort(BlocksByDiagonal(blocks))
+	if blocks[0].Height != 30 {
+		t.Error("Sort failed - first item")
+	}
+
+	if blocks[2].Width != 20 {
+		t.Error("Sort failed - last item")
+	}
+}
+
+func TestSortByWidthAscending(t *testing.T) {
+	blocks := getBlocks()
+	sort.Sort(BlocksByWidthAscending(blocks))
+	if blocks[0].Width != 5 {
+		t.Error("Sort failed - first item")
+	}
+
+	if blocks[2].Width != 25 {
+		t.Error("Sort failed - last item")
+	}
+}
@@ -0,0 +1,154 @@
+package packer
+
+// StableOptions controls FitStable.
+type StableOptions struct {
+	FitOptions
+	// Previous holds the blocks of an earlier canvas, placed where they
+	// should stay.
+	Previous Blocks
+	// MaxWaste is the fraction of the canvas, from 0 to 1, that may be left
+	// uncovered before FitStable gives up on the previous positions and
+	// packs the blocks from scratch like FitWith.  0 never repacks.
+	MaxWaste float64
+}
+
+// FitStable packs blocks like FitWith, but keeps every block found in
+// opts.Previous, with the same name and size, at its previous position.  The
+// other blocks, new or resized, are placed in the free space between them,
+// in the order of the first of opts.Layouts, growing the canvas right or
+// down when they do not fit.  With opts.Width set the canvas only grows
+// down.  Without any blocks to keep, or once the canvas wastes more than
+// opts.MaxWaste, the blocks are packed from scratch.  Blocks are matched
+// by name, so their names must be unique.
+func FitStable(blocks Blocks, opts StableOptions) *Canvas {
+	fo := opts.FitOptions.defaults()
+
+	previous := make(map[string]*Block)
+	for _, b := range opts.Previous {
+		previous[b.Name] = b
+	}
+
+	var pinned, added Blocks
+	for _, b := range blocks {
+		p, ok := previous[b.Name]
+		if ok && p.Width == b.Width && p.Height == b.Height && p.X >= 0 && p.Y >= 0 && !overlaps(pinned, p) {
+			pinned = append(pinned, &Block{Name: b.Name, X: p.X, Y: p.Y, Width: p.Width, Height: p.Height})
+		} else {
+			added = append(added, NewBlock(b.Name, b.Width, b.Height))
+		}
+	}
+
+	if len(pinned) == 0 {
+		return fitAll(blocks, fo)
+	}
+
+	// the space to place blocks in, as wide as the bin when there is one
+	bin := extent(pinned)
+	if fo.Width > 0 {
+		if bin.Width > binWidth(blocks, fo.Width) {
+			// the previous canvas no longer fits the bin width
+			return fitAll(blocks, fo)
+		}
+
+		bin.Width = binWidth(blocks, fo.Width)
+	}
+
+	fo.Layouts[0].sort(added)
+
+	placed := pinned
+	for _, b := range added {
+		if b.Width == 0 || b.Height == 0 {
+			// covers nothing, so fits anywhere
+			placed = append(placed, b)
+			continue
+		}
+
+		node := freeSpace(bin, placed).findNode(b.Width, b.Height)
+		if node == nil {
+			// Grow the way that keeps the canvas smallest, and squarest on a tie.
+			right := &Block{Width: bin.Width + b.Width, Height: max(bin.Height, b.Height)}
+			down := &Block{Width: max(bin.Width, b.Width), Height: bin.Height + b.Height}
+			if fo.Width == 0 && smaller(right, down) {
+				bin = right
+			} else {
+				bin = down
+			}
+
+			node = freeSpace(bin, placed).findNode(b.Width, b.Height)
+		}
+
+		node.Name = b.Name
+		placed = append(placed, node)
+	}
+
+	// keep the blocks in the order given
+	index := make(map[string]*Block, len(placed))
+	for _, b := range placed {
+		index[b.Name] = b
+	}
+
+	canvas := &Canvas{Root: extent(placed), Blocks: make(Blocks, 0, len(blocks)), Algorithm: AlgorithmMaxRectsBSSF, Layout: fo.Layouts[0]}
+	for _, b := range blocks {
+		canvas.Blocks = append(canvas.Blocks, index[b.Name])
+	}
+
+	fo.Sizing.padCanvas(canvas)
+	canvas.Score = fo.Scorer.Score(canvas)
+
+	area := float64(canvas.Root.Width) * float64(canvas.Root.Height)
+	if opts.MaxWaste > 0 && area > 0 && float64(canvas.Waste())/area > opts.MaxWaste {
+		return fitAll(blocks, fo)
+	}
+
+	return canvas
+}
+
+// extent returns a root just large enough to hold blocks.
+func extent(blocks Blocks) *Block {
+	root := NewBlock("#root#", 0, 0)
+	for _, b := range blocks {
+		root.Width = max(root.Width, b.X+b.Width)
+		root.Height = max(root.Height, b.Y+b.Height)
+	}
+
+	return root
+}
+
+// freeSpace returns the free rectangles of a bin holding blocks.
+func freeSpace(bin *Block, blocks Blocks) *maxRects {
+	m := &maxRects{
+		width:     bin.Width,
+		height:    bin.Height,
+		heuristic: bestShortSideFit,
+		free:      Blocks{NewBlock("", bin.Width, bin.Height)},
+	}
+
+	for _, b := range blocks {
+		m.place(b)
+	}
+
+	return m
+}
+
+// overlaps reports whether b overlaps any of blocks.
+func overlaps(blocks Blocks, b *Block) bool {
+	for _, o := range blocks {
+		if intersects(o, b) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// smaller reports whether a has a smaller area than b, or on a tie, a
+// shorter longest side.
+func smaller(a, b *Block) bool {
+	areaA := int64(a.Width) * int64(a.Height)
+	areaB := int64(b.Width) * int64(b.Height)
+	if areaA != areaB {
+		return areaA < areaB
+	}
+
+	return max(a.Width, a.Height) < max(b.Width, b.Height)
+}
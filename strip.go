@@ -0,0 +1,25 @@
+package packer
+
+// fitStrip places the blocks, in order, in a single column or, when
+// horizontal is set, a single row.  Strips suit backgrounds repeated along
+// the other axis with repeat-x or repeat-y.
+func fitStrip(blocks Blocks, horizontal bool) *Canvas {
+	root := NewBlock("#root#", 0, 0)
+	placed := make(Blocks, len(blocks))
+	for i, b := range blocks {
+		node := &Block{Name: b.Name, Width: b.Width, Height: b.Height}
+		if horizontal {
+			node.X = root.Width
+			root.Width += b.Width
+			root.Height = max(root.Height, b.Height)
+		} else {
+			node.Y = root.Height
+			root.Height += b.Height
+			root.Width = max(root.Width, b.Width)
+		}
+
+		placed[i] = node
+	}
+
+	return &Canvas{Root: root, Blocks: placed}
+}
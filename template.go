@@ -1,21 +1,387 @@
 package packer
 
+// Stylesheet is the data passed to stylesheet templates, both the built-in
+// ones and those given in Config.Template.
+type Stylesheet struct {
+	// CSSPath is the file path of the stylesheet.  In the HTML template it is
+	// relative to the HTML page, as are ImgPath and RetinaImgPath.
+	CSSPath string
+	// ImgPath is the file path of the sprite image.
+	ImgPath string
+	// RetinaImgPath is the file path of the retina image, only written when Retina is set.
+	RetinaImgPath string
+	// Retina is true when a 2x image was also created.
+	Retina bool
+	// ImgURL is the url of the directory holding the sprite image.
+	ImgURL string
+	// Format is the image format, png or jpg.
+	Format string
+	// Prefix is the class name shared by every image in the sprite.
+	Prefix string
+	// Name is the name of the sprite, without any file extension.
+	Name string
+	// Images holds one entry for each image in the sprite.
+	Images []SpriteImage
+
+	// ImgURI is the base64 data URI of the sprite image when Base64 is set.
+	ImgURI string
+	// RetinaURI is the base64 data URI of the retina image when Base64 and Retina are set.
+	RetinaURI string
+
+	// Image is the url of the sprite image used in the stylesheet, either
+	// ImgURL/Name.Format or ImgURI.
+	Image string
+	// RetinaImage is the url of the retina image used in the stylesheet,
+	// either ImgURL/Name@2x.Format or RetinaURI.
+	RetinaImage string
+
+	// Width of the (normal) sprite image in pixels.
+	Width int
+	// Height of the (normal) sprite image in pixels.
+	Height int
+
+	// Densities holds the images drawn at every density other than 1x, in
+	// increasing order of density.
+	Densities []DensityImage
+
+	// Pages holds one entry for each image when the sprite is split across
+	// several, because it is larger than Config.MaxWidth or MaxHeight.  The
+	// image fields above (ImgPath, Image, Width, Densities and so on) are
+	// then empty.
+	Pages []PageImage
+}
+
+// PageImage describes one image of a sprite split across several pages.
+type PageImage struct {
+	// Index is the number of the page, starting from 0.
+	Index int
+	// Selector lists the classes of every image on the page, for example
+	// ".sprite_home, .sprite_home:hover, .sprite_search".
+	Selector string
+	// ImgPath is the file path of the page image.
+	ImgPath string
+	// ImgURI is the base64 data URI of the page image when Base64 is set.
+	ImgURI string
+	// Image is the url of the page image used in the stylesheet, either
+	// ImgURL/Name-Index.Format or ImgURI.
+	Image string
+	// Width of the (normal) page image in pixels.
+	Width int
+	// Height of the (normal) page image in pixels.
+	Height int
+	// Densities holds the page drawn at every density other than 1x.
+	Densities []DensityImage
+}
+
+// DensityImage describes the sprite image drawn at one pixel density.  It
+// uses the same layout as the 1x image, so background positions and sizes
+// in pixels are shared by every density.
+type DensityImage struct {
+	// Density is the pixel density of the image, for example 2.
+	Density float64
+	// Tag is the suffix of the image name, for example "@2x".
+	Tag string
+	// MinRatio is the smallest device pixel ratio that should use this image.
+	MinRatio float64
+	// Image is the url of the image used in the stylesheet, either
+	// ImgURL/Name@Nx.Format or URI.
+	Image string
+	// URI is the base64 data URI of the image when Base64 is set.
+	URI string
+	// ImgPath is the file path of the image.
+	ImgPath string
+}
+
+// SpriteImage locates a single image within the sprite.
+type SpriteImage struct {
+	// Name is the css class name, the prefix and image name joined with '_'.
+	Name string
+	// Var is the name used for preprocessor variables, the prefix and image
+	// name joined with '-', for example "sprite-home".
+	Var string
+	// Hover is ":hover" when this image is the hover state of Name, or empty.
+	Hover string
+	// X is the (negative) horizontal background position in pixels.
+	X int
+	// Y is the (negative) vertical background position in pixels.
+	Y int
+	// Width of the image in pixels.
+	Width int
+	// Height of the image in pixels.
+	Height int
+	// Trimmed is set when the transparent edges of the image were cut off,
+	// see Config.Trim.  X, Y, Width and Height then locate only the pixels
+	// kept, which lie at TrimX, TrimY within the SourceWidth by SourceHeight
+	// image.
+	Trimmed      bool
+	TrimX        int
+	TrimY        int
+	SourceWidth  int
+	SourceHeight int
+	// Padding is the css padding giving the image its untrimmed size, the
+	// edges cut off it, or "0" when it was not trimmed.  It is only set when
+	// Config.Trim is, and the background is then drawn in the content box,
+	// so the padding never shows a neighbouring image.
+	Padding string
+	// Page is the index of the page holding the image, always 0 unless the
+	// sprite is split across several pages.
+	Page int
+}
+
+// CSSTemplate is the template for plain css stylesheets.
 const CSSTemplate = `
 .{{.Prefix}} {
-  background-image: url({{.ImgURL}}/{{.Name}}.{{.Format}});
-  background-repeat: no-repeat;
+  {{if not .Pages}}background-image: url({{.Image}});{{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});{{end}}
+  background-size: {{.Width}}px {{.Height}}px;{{end}}
+  {{end}}background-repeat: no-repeat;
   display: block;
 }
-
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx) {
+  .{{$.Prefix}} {
+    background-image: url({{.Image}});
+  }
+}
+{{end}}{{range $page := .Pages}}
+{{.Selector}} {
+  background-image: url({{.Image}});{{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});{{end}}
+  background-size: {{.Width}}px {{.Height}}px;{{end}}
+}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx) {
+  {{$page.Selector}} {
+    background-image: url({{.Image}});
+  }
+}
+{{end}}{{end}}
 {{range .Images}}
 .{{.Name}}{{.Hover}} {
   background-position: {{.X}}px {{.Y}}px;
   width: {{.Width}}px;
-  height: {{.Height}}px;
+  height: {{.Height}}px;{{if .Padding}}
+  padding: {{.Padding}};
+  box-sizing: content-box;
+  background-origin: content-box;
+  background-clip: content-box;{{end}}
+}
+{{end}}
+`
+
+// SCSSTemplate is the template for scss stylesheets.
+const SCSSTemplate = `
+{{range .Images}}${{.Var}}-x: {{.X}}px;
+${{.Var}}-y: {{.Y}}px;
+${{.Var}}-width: {{.Width}}px;
+${{.Var}}-height: {{.Height}}px;
+${{.Var}}: {{.X}}px {{.Y}}px {{.Width}}px {{.Height}}px;{{if .Padding}}
+${{.Var}}-padding: {{.Padding}};{{end}}
+{{end}}
+@mixin {{.Prefix}}-position($sprite) {
+  background-position: nth($sprite, 1) nth($sprite, 2);
+}
+
+@mixin {{.Prefix}}-size($sprite) {
+  width: nth($sprite, 3);
+  height: nth($sprite, 4);
+}
+
+.{{.Prefix}} {
+  {{if not .Pages}}background-image: url({{.Image}});{{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});{{end}}
+  background-size: {{.Width}}px {{.Height}}px;{{end}}
+  {{end}}background-repeat: no-repeat;
+  display: block;
+}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx) {
+  .{{$.Prefix}} {
+    background-image: url({{.Image}});
+  }
+}
+{{end}}{{range $page := .Pages}}
+{{.Selector}} {
+  background-image: url({{.Image}});{{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});{{end}}
+  background-size: {{.Width}}px {{.Height}}px;{{end}}
+}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx) {
+  {{$page.Selector}} {
+    background-image: url({{.Image}});
+  }
+}
+{{end}}{{end}}{{range .Images}}
+.{{.Name}}{{.Hover}} {
+  @include {{$.Prefix}}-position(${{.Var}});
+  @include {{$.Prefix}}-size(${{.Var}});{{if .Padding}}
+  padding: ${{.Var}}-padding;
+  box-sizing: content-box;
+  background-origin: content-box;
+  background-clip: content-box;{{end}}
+}
+{{end}}
+`
+
+// SassTemplate is the template for stylesheets in the indented sass syntax.
+const SassTemplate = `
+{{range .Images}}${{.Var}}-x: {{.X}}px
+${{.Var}}-y: {{.Y}}px
+${{.Var}}-width: {{.Width}}px
+${{.Var}}-height: {{.Height}}px
+${{.Var}}: {{.X}}px {{.Y}}px {{.Width}}px {{.Height}}px{{if .Padding}}
+${{.Var}}-padding: {{.Padding}}{{end}}
+{{end}}
+={{.Prefix}}-position($sprite)
+  background-position: nth($sprite, 1) nth($sprite, 2)
+
+={{.Prefix}}-size($sprite)
+  width: nth($sprite, 3)
+  height: nth($sprite, 4)
+
+.{{.Prefix}}
+  {{if not .Pages}}background-image: url({{.Image}}){{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}})
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}}){{end}}
+  background-size: {{.Width}}px {{.Height}}px{{end}}
+  {{end}}background-repeat: no-repeat
+  display: block
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx)
+  .{{$.Prefix}}
+    background-image: url({{.Image}})
+{{end}}{{range $page := .Pages}}
+{{.Selector}}
+  background-image: url({{.Image}}){{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}})
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}}){{end}}
+  background-size: {{.Width}}px {{.Height}}px{{end}}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx)
+  {{$page.Selector}}
+    background-image: url({{.Image}})
+{{end}}{{end}}{{range .Images}}
+.{{.Name}}{{.Hover}}
+  +{{$.Prefix}}-position(${{.Var}})
+  +{{$.Prefix}}-size(${{.Var}}){{if .Padding}}
+  padding: ${{.Var}}-padding
+  box-sizing: content-box
+  background-origin: content-box
+  background-clip: content-box{{end}}
+{{end}}
+`
+
+// LESSTemplate is the template for less stylesheets.
+const LESSTemplate = `
+{{range .Images}}@{{.Var}}-x: {{.X}}px;
+@{{.Var}}-y: {{.Y}}px;
+@{{.Var}}-width: {{.Width}}px;
+@{{.Var}}-height: {{.Height}}px;
+@{{.Var}}: {{.X}}px {{.Y}}px {{.Width}}px {{.Height}}px;{{if .Padding}}
+@{{.Var}}-padding: {{.Padding}};{{end}}
+{{end}}
+.{{.Prefix}}-position(@sprite) {
+  background-position: extract(@sprite, 1) extract(@sprite, 2);
+}
+
+.{{.Prefix}}-size(@sprite) {
+  width: extract(@sprite, 3);
+  height: extract(@sprite, 4);
+}
+
+.{{.Prefix}} {
+  {{if not .Pages}}background-image: url({{.Image}});{{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});{{end}}
+  background-size: {{.Width}}px {{.Height}}px;{{end}}
+  {{end}}background-repeat: no-repeat;
+  display: block;
+}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx) {
+  .{{$.Prefix}} {
+    background-image: url({{.Image}});
+  }
+}
+{{end}}{{range $page := .Pages}}
+{{.Selector}} {
+  background-image: url({{.Image}});{{if .Densities}}{{if not .ImgURI}}
+  background-image: -webkit-image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});
+  background-image: image-set(url({{.Image}}) 1x{{range .Densities}}, url({{.Image}}) {{.Density}}x{{end}});{{end}}
+  background-size: {{.Width}}px {{.Height}}px;{{end}}
+}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx) {
+  {{$page.Selector}} {
+    background-image: url({{.Image}});
+  }
 }
+{{end}}{{end}}{{range .Images}}
+.{{.Name}}{{.Hover}} {
+  .{{$.Prefix}}-position(@{{.Var}});
+  .{{$.Prefix}}-size(@{{.Var}});{{if .Padding}}
+  padding: @{{.Var}}-padding;
+  box-sizing: content-box;
+  background-origin: content-box;
+  background-clip: content-box;{{end}}
+}
+{{end}}
+`
+
+// StylusTemplate is the template for stylus stylesheets.
+const StylusTemplate = `
+{{range .Images}}${{.Var}}-x = {{.X}}px
+${{.Var}}-y = {{.Y}}px
+${{.Var}}-width = {{.Width}}px
+${{.Var}}-height = {{.Height}}px
+${{.Var}} = {{.X}}px {{.Y}}px {{.Width}}px {{.Height}}px{{if .Padding}}
+${{.Var}}-padding = {{.Padding}}{{end}}
+{{end}}
+{{.Prefix}}-position($sprite)
+  background-position $sprite[0] $sprite[1]
+
+{{.Prefix}}-size($sprite)
+  width $sprite[2]
+  height $sprite[3]
+
+.{{.Prefix}}
+  {{if not .Pages}}background-image url('{{.Image}}'){{if .Densities}}{{if not .ImgURI}}
+  background-image -webkit-image-set(url('{{.Image}}') 1x{{range .Densities}}, url('{{.Image}}') {{.Density}}x{{end}})
+  background-image image-set(url('{{.Image}}') 1x{{range .Densities}}, url('{{.Image}}') {{.Density}}x{{end}}){{end}}
+  background-size {{.Width}}px {{.Height}}px{{end}}
+  {{end}}background-repeat no-repeat
+  display block
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx)
+  .{{$.Prefix}}
+    background-image url('{{.Image}}')
+{{end}}{{range $page := .Pages}}
+{{.Selector}}
+  background-image url('{{.Image}}'){{if .Densities}}{{if not .ImgURI}}
+  background-image -webkit-image-set(url('{{.Image}}') 1x{{range .Densities}}, url('{{.Image}}') {{.Density}}x{{end}})
+  background-image image-set(url('{{.Image}}') 1x{{range .Densities}}, url('{{.Image}}') {{.Density}}x{{end}}){{end}}
+  background-size {{.Width}}px {{.Height}}px{{end}}
+{{range .Densities}}
+@media (-webkit-min-device-pixel-ratio: {{.MinRatio}}), (min-resolution: {{.MinRatio}}dppx)
+  {{$page.Selector}}
+    background-image url('{{.Image}}')
+{{end}}{{end}}{{range .Images}}
+.{{.Name}}{{.Hover}}
+  {{$.Prefix}}-position(${{.Var}})
+  {{$.Prefix}}-size(${{.Var}}){{if .Padding}}
+  padding ${{.Var}}-padding
+  box-sizing content-box
+  background-origin content-box
+  background-clip content-box{{end}}
 {{end}}
 `
 
+// HTMLTemplate is the template for the HTML page used to test a sprite.
 const HTMLTemplate = `
 <html>
   <head>
@@ -27,45 +393,28 @@ const HTMLTemplate = `
   </head>
   <body>
     <table cellspacing="40">
-      <tr><th>Sprites</th><th>Image</th></tr>
+      <tr><th>Sprites</th>{{if .Pages}}{{range $page := .Pages}}<th>Page {{.Index}}</th>{{range .Densities}}<th>{{$page.Index}}{{.Tag}}</th>{{end}}{{end}}{{else}}<th>Image</th>{{range .Densities}}<th>{{.Tag}}</th>{{end}}{{end}}</tr>
       <tr>
         <td>
           <table cellpadding="4">
             <th>Name</th><th>Icon</th><th>(X,Y)</th><th>W x H</th></tr>
-            {{range .Images}}{{if not .Hover }}<tr><td>{{.Name}}</td><td><div class="sprite {{.Name}}"></div></td><td>({{.X}}, {{.Y}})<td>{{.Width}} x {{.Height}}</td></tr>{{end}}{{end}}
+            {{range .Images}}{{if not .Hover }}<tr><td>{{.Name}}</td><td><div class="{{$.Prefix}} {{.Name}}"></div></td><td>({{.X}}, {{.Y}})<td>{{.Width}} x {{.Height}}</td></tr>{{end}}{{end}}
           </table>
-        </td>
+        </td>{{if .Pages}}{{range .Pages}}
+        <td valign=top>
+          <img src="{{if .ImgURI}}{{.ImgURI}}{{else}}{{.ImgPath}}{{end}}">
+        </td>{{range .Densities}}
+        <td valign=top>
+          <img src="{{if .URI}}{{.URI}}{{else}}{{.ImgPath}}{{end}}">
+        </td>{{end}}{{end}}{{else}}
         <td valign=top>
-          <img src="{{.ImgPath}}">
-        </td>
+          <img src="{{if .ImgURI}}{{.ImgURI}}{{else}}{{.ImgPath}}{{end}}">
+        </td>{{range .Densities}}
+        <td valign=top>
+          <img src="{{if .URI}}{{.URI}}{{else}}{{.ImgPath}}{{end}}">
+        </td>{{end}}{{end}}
       </tr>
     </table>
   </body>
 </html>
 `
-
-/*
-{{#sprite}}
-{{class}} {
-  background-image: url('{{{escaped_image}}}');
-}
-
-{{/sprite}}
-{{#retina}}
-@media (min--moz-device-pixel-ratio: 1.5), (-o-min-device-pixel-ratio: 3/2), (-webkit-min-device-pixel-ratio: 1.5), (min-device-pixel-ratio: 1.5), (min-resolution: 1.5dppx) {
-  {{class}} {
-    background-image: url('{{{escaped_image}}}');
-    background-size: {{px.total_width}} {{px.total_height}};
-  }
-}
-
-{{/retina}}
-{{#items}}
-{{class}} {
-  background-position: {{px.offset_x}} {{px.offset_y}};
-  width: {{px.width}};
-  height: {{px.height}};
-}
-
-{{/items}}
-*/
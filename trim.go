@@ -0,0 +1,93 @@
+package packer
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// trimBox is the part of an image kept by trimming, in 1x pixels snapped
+// to the density grid.
+type trimBox struct {
+	// rect holds the pixels kept, within the untrimmed image
+	rect image.Rectangle
+	// size is the size of the untrimmed image
+	size image.Point
+}
+
+// trimImages crops every image to the pixels more opaque than TrimThreshold,
+// cutting the same box, snapped to the density grid, from every file of the
+// image.  It returns the boxes of the images that were cropped.  Images
+// without any such pixels are left alone.
+func (c *Config) trimImages(images map[string]sourceImage) map[string]trimBox {
+	trims := make(map[string]trimBox)
+	for name, src := range images {
+		master, md := src.master()
+		full := image.Rect(0, 0, c.snapSource(master.img.Bounds().Dx(), md), c.snapSource(master.img.Bounds().Dy(), md))
+
+		// the files of an image may differ, keep what any of them shows
+		var box image.Rectangle
+		for d, f := range src {
+			r := opaqueBounds(f.img, c.TrimThreshold)
+			if r.Empty() {
+				continue
+			}
+
+			box = box.Union(image.Rect(c.floorSource(r.Min.X, d), c.floorSource(r.Min.Y, d), c.snapSource(r.Max.X, d), c.snapSource(r.Max.Y, d)))
+		}
+
+		box = box.Intersect(full)
+		if box.Empty() || box == full {
+			continue
+		}
+
+		for d, f := range src {
+			b := f.img.Bounds()
+			crop := image.Rect(scale(box.Min.X, d), scale(box.Min.Y, d), scale(box.Max.X, d), scale(box.Max.Y, d))
+			f.img = subImage(f.img, crop.Add(b.Min).Intersect(b))
+		}
+
+		trims[name] = trimBox{rect: box, size: full.Max}
+	}
+
+	return trims
+}
+
+// floorSource converts a length in pixels at density d to 1x pixels, rounded
+// down to a multiple of the grid size.
+func (c *Config) floorSource(v int, d float64) int {
+	cell := float64(c.grid) * d
+	return int(math.Floor(float64(v)/cell+1e-9)) * c.grid
+}
+
+// opaqueBounds returns the smallest rectangle, relative to the top left of
+// img, holding every pixel with an alpha above threshold (0 to 255).
+func opaqueBounds(img image.Image, threshold int) image.Rectangle {
+	b := img.Bounds()
+	limit := uint32(threshold) * 0x101
+
+	var r image.Rectangle
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a > limit {
+				r = r.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+
+	return r.Sub(b.Min)
+}
+
+// subImage returns the part of img within r, sharing its pixels when it can.
+func subImage(img image.Image, r image.Rectangle) image.Image {
+	if s, ok := img.(interface {
+		SubImage(image.Rectangle) image.Image
+	}); ok {
+		return s.SubImage(r)
+	}
+
+	dst := image.NewRGBA(r)
+	draw.Draw(dst, r, img, r.Min, draw.Src)
+
+	return dst
+}